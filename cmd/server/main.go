@@ -1,11 +1,16 @@
 package main
 
 import (
+	"github.com/CollabTED/CollabTed-Backend/config"
 	_ "github.com/CollabTED/CollabTed-Backend/docs"
 	"github.com/CollabTED/CollabTed-Backend/internal/server"
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
 	"github.com/CollabTED/CollabTed-Backend/internal/ws"
 	"github.com/CollabTED/CollabTed-Backend/pkg/cloudinary"
+	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
+	"github.com/CollabTED/CollabTed-Backend/pkg/mail"
 	"github.com/CollabTED/CollabTed-Backend/pkg/redis"
+	"github.com/CollabTED/CollabTed-Backend/pkg/search"
 	"github.com/CollabTED/CollabTed-Backend/prisma"
 )
 
@@ -16,11 +21,28 @@ import (
 // @BasePath		/api/v1
 func main() {
 	redis.Connect()
+	mail.Connect()
 	cloudinary.Connect()
 	s := server.NewServer(":8080")
 	prisma.Connect()
+	services.Migrate()
+	if created, ok := search.Connect(); ok {
+		if created || config.SEARCH_REINDEX {
+			go func() {
+				if err := services.NewSearchService().Backfill(); err != nil {
+					logger.LogError().Msgf("Failed to backfill search index: %v", err)
+				}
+			}()
+		}
+		go services.NewSearchService().WatchReindex()
+	}
 	go ws.Hub()
+	go ws.Relay()
+	go ws.Heartbeat()
 	go ws.WatchConnect()
 	go ws.WatchDisconnect()
+	go ws.DispatchScheduled()
+	go services.NewDigestService().Run()
+	go services.NewWebhookService().Run()
 	s.Run()
 }
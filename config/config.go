@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
 	"github.com/CollabTED/CollabTed-Backend/pkg/types"
@@ -25,6 +26,11 @@ var (
 	LIVEKIT_API_SECRET string
 	CLOUDINARY_URL     string
 	MONGO_URI          string
+	SEARCH_INDEX_PATH  string
+	SEARCH_REINDEX     bool
+	MAIL_TRANSPORT     string
+	MAIL_OUTBOX_PATH   string
+	OPERATOR_IDS       []string
 )
 
 func Load() {
@@ -45,7 +51,11 @@ func Load() {
 	EMAIL_HOST = mustGetEnv("EMAIL_HOST")
 	EMAIL_PORT = mustGetEnv("EMAIL_PORT")
 	EMAIL = mustGetEnv("EMAIL")
-	EMAIL_PASSWORD = mustGetEnv("EMAIL_PASSWORD")
+	// left empty for a local SMTP server without authentication
+	EMAIL_PASSWORD = getEnv("EMAIL_PASSWORD", "")
+
+	MAIL_TRANSPORT = getEnv("MAIL_TRANSPORT", "smtp")
+	MAIL_OUTBOX_PATH = getEnv("MAIL_OUTBOX_PATH", "data/outbox")
 
 	SECURE_COOKIE = mustParseBool("SECURE_COOKIE", true)
 	HOST_URL = mustGetEnv("HOST_URL")
@@ -55,6 +65,14 @@ func Load() {
 	LIVEKIT_API_SECRET = mustGetEnv("LIVEKIT_API_SECRET")
 	CLOUDINARY_URL = mustGetEnv("CLOUDINARY_URL")
 
+	SEARCH_INDEX_PATH = getEnv("SEARCH_INDEX_PATH", "data/messages.bleve")
+	// indexes every message again at startup, for a replica whose index
+	// missed writes
+	SEARCH_REINDEX = mustParseBool("SEARCH_REINDEX", false)
+
+	// users allowed on operator routes such as /ws/stats, none by default
+	OPERATOR_IDS = getList("OPERATOR_IDS")
+
 	MONGO_URI = getMongoURI()
 	logger.Logger.Info().Msgf("Starting %s environment", os.Getenv("APP_ENV"))
 }
@@ -104,6 +122,25 @@ func mustGetEnv(key string) string {
 	return value
 }
 
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// getList reads a comma separated list, skipping empty entries.
+func getList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func mustParseBool(key string, defaultValue bool) bool {
 	value := os.Getenv(key)
 	if value == "" {
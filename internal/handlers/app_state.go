@@ -20,7 +20,8 @@ func NewAppStateHandler() *AppStateHandler {
 
 func (h *AppStateHandler) GetAppState(c echo.Context) error {
 	userWorkspaceId := c.Param("userworkspaceId")
-	appState, err := h.srv.GetAppState(userWorkspaceId)
+	claims := c.Get("user").(*types.Claims)
+	appState, err := h.srv.GetAppState(claims.ID, userWorkspaceId)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -30,16 +31,28 @@ func (h *AppStateHandler) GetAppState(c echo.Context) error {
 
 func (h *AppStateHandler) UpdateAppState(c echo.Context) error {
 	userWorkspaceId := c.Param("userworkspaceId")
+	claims := c.Get("user").(*types.Claims)
 
 	var req types.AppStateUpdateRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, "Invalid request format")
 	}
 
-	appState, err := h.srv.UpdateAppState(userWorkspaceId, req.Action, req.Value)
+	appState, err := h.srv.UpdateAppState(claims.ID, userWorkspaceId, req)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
 	return c.JSON(http.StatusOK, appState)
 }
+
+func (h *AppStateHandler) GetChannelReads(c echo.Context) error {
+	channelId := c.Param("channelId")
+	claims := c.Get("user").(*types.Claims)
+	reads, err := h.srv.GetChannelReads(claims.ID, channelId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, reads)
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
 	"github.com/CollabTED/CollabTed-Backend/pkg/mail"
 	"github.com/CollabTED/CollabTed-Backend/pkg/types"
 	"github.com/CollabTED/CollabTed-Backend/pkg/utils"
@@ -16,12 +17,14 @@ import (
 
 type authHandler struct {
 	srv      *services.AuthService
+	tickets  *services.TicketService
 	verifier *mail.EmailVerifier
 }
 
 func NewAuthHandler() *authHandler {
 	return &authHandler{
 		srv:      services.NewAuthService(),
+		tickets:  services.NewTicketService(),
 		verifier: mail.NewVerifier(),
 	}
 }
@@ -92,13 +95,16 @@ func (h *authHandler) Register(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err)
 	}
 
-	err = h.verifier.SendVerfication(user.ID, []string{user.Email})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
+	// the account exists either way, failing here would only make the
+	// client retry a registration that can't succeed anymore
+	message := "verification email sent"
+	if err := h.verifier.SendVerfication(user.ID, []string{user.Email}); err != nil {
+		logger.LogError().Msgf("Failed to queue the verification email of %s: %v", user.ID, err)
+		message = "account created, but the verification email could not be sent"
 	}
 
 	return c.JSON(http.StatusOK, types.Response{
-		"message": "verification email sent",
+		"message": message,
 		"userID":  user.ID,
 	})
 }
@@ -153,6 +159,23 @@ func (h *authHandler) Me(c echo.Context) error {
 	return c.JSON(http.StatusOK, claims)
 }
 
+// IssueTicket returns a one-time ticket to authenticate a WebSocket or SSE
+// connection with ?ticket=, for clients that can't send the cookie or an
+// Authorization header on those.
+func (h *authHandler) IssueTicket(c echo.Context) error {
+	claims := c.Get("user").(*types.Claims)
+
+	ticket, err := h.tickets.IssueTicket(claims)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"ticket":    ticket,
+		"expiresIn": int(services.TicketTTL.Seconds()),
+	})
+}
+
 func (h *authHandler) Logout(c echo.Context) error {
 	if err := utils.DeleteJWTCookie(c.Response().Writer); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to delete JWT cookie")
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+	"github.com/labstack/echo/v4"
+)
+
+type botHandler struct {
+	srv    *services.BotService
+	wrkSrv *services.WorkspaceService
+}
+
+func NewBotHandler() *botHandler {
+	return &botHandler{
+		srv:    services.NewBotService(),
+		wrkSrv: services.NewWorkspaceService(),
+	}
+}
+
+func (h *botHandler) CreateBot(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	var data types.BotD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	bot, err := h.srv.CreateBot(claims.ID, workspaceID, data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusCreated, bot)
+}
+
+func (h *botHandler) ListBots(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	bots, err := h.srv.ListBots(workspaceID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, bots)
+}
+
+func (h *botHandler) DeleteBot(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	botID := c.Param("botId")
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	if err := h.srv.DeleteBot(workspaceID, botID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *botHandler) CreateToken(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	botID := c.Param("botId")
+	var data types.BotTokenD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	token, err := h.srv.CreateToken(claims.ID, workspaceID, botID, data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusCreated, token)
+}
+
+func (h *botHandler) ListTokens(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	botID := c.Param("botId")
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	tokens, err := h.srv.ListTokens(workspaceID, botID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+func (h *botHandler) RevokeToken(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	botID := c.Param("botId")
+	tokenID := c.Param("tokenId")
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	token, err := h.srv.RevokeToken(workspaceID, botID, tokenID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, token)
+}
+
+func (h *botHandler) ListAuditLog(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	botID := c.Param("botId")
+	page, err := strconv.Atoi(c.QueryParam("p"))
+	if err != nil {
+		page = 1
+	}
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	entries, err := h.srv.ListAuditLog(workspaceID, botID, c.QueryParam("tokenId"), page)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+func (h *botHandler) requireAdmin(userID, workspaceID string) error {
+	isAdmin, err := h.wrkSrv.CanUserPerformAction(userID, workspaceID, db.UserRoleAdmin)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "you are not authorized to perform this action")
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/internal/ws"
 	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
 	"github.com/labstack/echo/v4"
 )
 
@@ -20,7 +23,9 @@ func NewChannelHandler() *channelHandler {
 
 func (h *channelHandler) GetWorkspaceChannels(c echo.Context) error {
 	worksapceID := c.Param("workspaceId")
-	channels, err := h.srv.ListChannelsByWorkspace(worksapceID)
+	claims := c.Get("user").(*types.Claims)
+	includeArchived := c.QueryParam("archived") == "true"
+	channels, err := h.srv.ListChannelsByWorkspace(claims.ID, worksapceID, includeArchived)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -29,8 +34,12 @@ func (h *channelHandler) GetWorkspaceChannels(c echo.Context) error {
 }
 
 func (h *channelHandler) GetChannel(c echo.Context) error {
-	worksapceID := c.Param("workspaceId")
-	channel, err := h.srv.GetChannelById(worksapceID)
+	channelID := c.Param("channelId")
+	claims := c.Get("user").(*types.Claims)
+	channel, err := h.srv.GetChannel(claims.ID, channelID)
+	if errors.Is(err, services.ErrChannelNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -38,8 +47,19 @@ func (h *channelHandler) GetChannel(c echo.Context) error {
 	return c.JSON(http.StatusOK, channel)
 }
 
+// DeleteChannel permanently deletes a channel. It is restricted to workspace
+// admins and must be confirmed with ?confirm=true, archiving is the default.
 func (h *channelHandler) DeleteChannel(c echo.Context) error {
 	channelID := c.Param("channelId")
+	claims := c.Get("user").(*types.Claims)
+
+	if c.QueryParam("confirm") != "true" {
+		return echo.NewHTTPError(http.StatusBadRequest, "deleting a channel is permanent, confirm with ?confirm=true or archive it instead")
+	}
+	if err := h.requireAdmin(claims.ID, channelID); err != nil {
+		return err
+	}
+
 	channel, err := h.srv.DeleteChannel(channelID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -48,6 +68,38 @@ func (h *channelHandler) DeleteChannel(c echo.Context) error {
 	return c.JSON(http.StatusOK, channel)
 }
 
+func (h *channelHandler) ArchiveChannel(c echo.Context) error {
+	channelID := c.Param("channelId")
+	claims := c.Get("user").(*types.Claims)
+
+	if err := h.requireManager(claims.ID, channelID); err != nil {
+		return err
+	}
+
+	channel, err := h.srv.ArchiveChannel(channelID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, channel)
+}
+
+func (h *channelHandler) UnarchiveChannel(c echo.Context) error {
+	channelID := c.Param("channelId")
+	claims := c.Get("user").(*types.Claims)
+
+	if err := h.requireAdmin(claims.ID, channelID); err != nil {
+		return err
+	}
+
+	channel, err := h.srv.UnarchiveChannel(channelID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, channel)
+}
+
 func (h *channelHandler) CreateChannel(c echo.Context) error {
 	var data types.ChannelD
 	if err := c.Bind(&data); err != nil {
@@ -84,10 +136,177 @@ func (h *channelHandler) AddParticipants(c echo.Context) error {
 	if err := c.Bind(&data); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
-	participant, err := h.srv.AddParticipants(data.WorkspaceID, data.ChannelID, data.UsersID)
+	claims := c.Get("user").(*types.Claims)
+
+	if err := h.requireManager(claims.ID, data.ChannelID); err != nil {
+		return err
+	}
+
+	participant, message, err := h.srv.AddParticipants(claims.ID, data.ChannelID, data.UsersID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	if message != nil {
+		h.announce(message)
+	}
 
 	return c.JSON(http.StatusOK, participant)
 }
+
+func (h *channelHandler) GetOrCreateDirectChannel(c echo.Context) error {
+	var data types.DirectChannelD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+
+	channel, err := h.srv.GetOrCreateDirectChannel(claims.ID, data.WorkspaceID, data.UsersID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, channel)
+}
+
+func (h *channelHandler) GetDirectChannels(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	claims := c.Get("user").(*types.Claims)
+
+	channels, err := h.srv.ListDirectChannels(claims.ID, workspaceID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, channels)
+}
+
+func (h *channelHandler) GetJoinableChannels(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	claims := c.Get("user").(*types.Claims)
+
+	channels, err := h.srv.ListJoinableChannels(claims.ID, workspaceID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, channels)
+}
+
+func (h *channelHandler) SetVisibility(c echo.Context) error {
+	channelID := c.Param("channelId")
+	claims := c.Get("user").(*types.Claims)
+
+	var data types.ChannelVisibilityD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.requireManager(claims.ID, channelID); err != nil {
+		return err
+	}
+
+	channel, err := h.srv.SetVisibility(channelID, data.Kind)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, channel)
+}
+
+func (h *channelHandler) JoinChannel(c echo.Context) error {
+	channelID := c.Param("channelId")
+	claims := c.Get("user").(*types.Claims)
+
+	channel, message, err := h.srv.JoinChannel(claims.ID, channelID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if message != nil {
+		ws.SendSystemMessage(message, channel.Participants())
+	}
+
+	return c.JSON(http.StatusOK, channel)
+}
+
+func (h *channelHandler) LeaveChannel(c echo.Context) error {
+	channelID := c.Param("channelId")
+	claims := c.Get("user").(*types.Claims)
+
+	channel, message, err := h.srv.LeaveChannel(claims.ID, channelID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if message != nil {
+		ws.SendSystemMessage(message, channel.Participants())
+	}
+
+	return c.JSON(http.StatusOK, "Left the channel successfully")
+}
+
+func (h *channelHandler) RemoveParticipants(c echo.Context) error {
+	var data types.ParticipantD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+
+	if err := h.requireManager(claims.ID, data.ChannelID); err != nil {
+		return err
+	}
+
+	removed, message, err := h.srv.RemoveParticipants(claims.ID, data.ChannelID, data.UsersID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if message != nil {
+		// removed users are told too so their clients can drop the channel
+		channel, err := h.srv.GetChannelById(data.ChannelID)
+		if err == nil {
+			ws.SendSystemMessage(message, append(channel.Participants(), removed...))
+		}
+	}
+
+	return c.JSON(http.StatusOK, removed)
+}
+
+// requireManager fails unless the user created the channel or administers its
+// workspace.
+func (h *channelHandler) requireManager(userID, channelID string) error {
+	channel, err := h.srv.GetChannelById(channelID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	canManage, err := h.srv.CanManageChannel(userID, channel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !canManage {
+		return echo.NewHTTPError(http.StatusForbidden, "you are not authorized to perform this action")
+	}
+	return nil
+}
+
+// requireAdmin fails unless the user administers the channel's workspace.
+func (h *channelHandler) requireAdmin(userID, channelID string) error {
+	channel, err := h.srv.GetChannelById(channelID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	isAdmin, err := services.NewWorkspaceService().CanUserPerformAction(userID, channel.WorkspaceID, db.UserRoleAdmin)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "you are not authorized to perform this action")
+	}
+	return nil
+}
+
+// announce delivers a system message to the current participants of its channel.
+func (h *channelHandler) announce(message *db.MessageModel) {
+	channel, err := h.srv.GetChannelById(message.ChannelID)
+	if err != nil {
+		return
+	}
+	ws.SendSystemMessage(message, channel.Participants())
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/CollabTED/CollabTed-Backend/config"
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/internal/ws"
+	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+	"github.com/labstack/echo/v4"
+)
+
+type incomingWebhookHandler struct {
+	srv        *services.IncomingWebhookService
+	channelSrv *services.ChannelService
+	msgSrv     *services.MessageService
+}
+
+func NewIncomingWebhookHandler() *incomingWebhookHandler {
+	return &incomingWebhookHandler{
+		srv:        services.NewIncomingWebhookService(),
+		channelSrv: services.NewChannelService(),
+		msgSrv:     services.NewMessageService(),
+	}
+}
+
+// CreateIncomingWebhook returns the webhook with its token and the URL to
+// post to, neither of which can be retrieved later.
+func (h *incomingWebhookHandler) CreateIncomingWebhook(c echo.Context) error {
+	channelID := c.Param("channelId")
+	var data types.IncomingWebhookD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+
+	channel, err := h.requireManager(claims.ID, channelID)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := h.srv.CreateIncomingWebhook(claims.ID, channel, data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusCreated, map[string]any{
+		"webhook": webhook,
+		"url":     fmt.Sprintf("%s/api/v1/hooks/%s/%s", config.HOST_URL, webhook.ID, webhook.Token),
+	})
+}
+
+func (h *incomingWebhookHandler) ListIncomingWebhooks(c echo.Context) error {
+	channelID := c.Param("channelId")
+	claims := c.Get("user").(*types.Claims)
+
+	if _, err := h.requireManager(claims.ID, channelID); err != nil {
+		return err
+	}
+
+	webhooks, err := h.srv.ListIncomingWebhooks(channelID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+func (h *incomingWebhookHandler) DeleteIncomingWebhook(c echo.Context) error {
+	channelID := c.Param("channelId")
+	webhookID := c.Param("webhookId")
+	claims := c.Get("user").(*types.Claims)
+
+	if _, err := h.requireManager(claims.ID, channelID); err != nil {
+		return err
+	}
+
+	if err := h.srv.DeleteIncomingWebhook(channelID, webhookID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// PostMessage is called by integrations, authenticated by the token in the
+// webhook's URL rather than by a user.
+func (h *incomingWebhookHandler) PostMessage(c echo.Context) error {
+	webhook, err := h.srv.Authenticate(c.Param("webhookId"), c.Param("token"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	var payload types.IncomingWebhookPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	msg, err := h.srv.ToMessage(payload)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	channel, err := h.channelSrv.GetChannelById(webhook.ChannelID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "channel not found")
+	}
+	if channel.IsArchived {
+		return echo.NewHTTPError(http.StatusConflict, services.ErrChannelArchived.Error())
+	}
+
+	msg.SenderID = webhook.BotID
+	msg.ChannelID = channel.ID
+	saved, err := h.msgSrv.SendMessage(msg)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := ws.SendChatMessage(channel, saved); err != nil {
+		logger.LogError().Msgf("Failed to send message %s of incoming webhook %s: %v", saved.ID, webhook.ID, err)
+	}
+	return c.JSON(http.StatusCreated, saved)
+}
+
+func (h *incomingWebhookHandler) requireManager(userID, channelID string) (*db.ChannelModel, error) {
+	channel, err := h.channelSrv.GetChannelById(channelID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	canManage, err := h.channelSrv.CanManageChannel(userID, channel)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !canManage {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "you are not authorized to perform this action")
+	}
+	return channel, nil
+}
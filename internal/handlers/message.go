@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/internal/ws"
 	"github.com/CollabTED/CollabTed-Backend/pkg/cloudinary"
 	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
 	"github.com/CollabTED/CollabTed-Backend/pkg/types"
@@ -24,12 +26,45 @@ func NewMessageHandler() *messageHandler {
 
 func (h *messageHandler) GetMessages(c echo.Context) error {
 	channelId := c.Param("channelId")
-	page := c.QueryParam("p")
-	pageInt, err := strconv.Atoi(page)
+	claims := c.Get("user").(*types.Claims)
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
 	if err != nil {
-		pageInt = 1
+		limit = 0
+	}
+	data, err := h.srv.GetMessagesByChannel(claims.ID, channelId, types.MessageCursorD{
+		Before: c.QueryParam("before"),
+		After:  c.QueryParam("after"),
+		Around: c.QueryParam("around"),
+		Limit:  limit,
+	})
+	if errors.Is(err, services.ErrChannelNotFound) || errors.Is(err, services.ErrAnchorNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+func (h *messageHandler) GetThread(c echo.Context) error {
+	messageId := c.Param("messageId")
+	claims := c.Get("user").(*types.Claims)
+	data, err := h.srv.GetThread(claims.ID, messageId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+func (h *messageHandler) GetMentions(c echo.Context) error {
+	workspaceId := c.Param("workspaceId")
+	claims := c.Get("user").(*types.Claims)
+
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil {
+		limit = 0
 	}
-	data, err := h.srv.GetMessagesByChannel(channelId, pageInt)
+	data, err := h.srv.GetMentions(claims.ID, workspaceId, limit)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -38,12 +73,16 @@ func (h *messageHandler) GetMessages(c echo.Context) error {
 
 func (h *messageHandler) GetPinnedMessages(c echo.Context) error {
 	channelId := c.Param("channelId")
+	claims := c.Get("user").(*types.Claims)
 	page := c.QueryParam("p")
 	pageInt, err := strconv.Atoi(page)
 	if err != nil {
 		pageInt = 1
 	}
-	data, err := h.srv.GetPinnedMessages(channelId, pageInt)
+	data, err := h.srv.GetPinnedMessages(claims.ID, channelId, pageInt)
+	if errors.Is(err, services.ErrChannelNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -52,7 +91,11 @@ func (h *messageHandler) GetPinnedMessages(c echo.Context) error {
 
 func (h *messageHandler) GetAttachments(c echo.Context) error {
 	channelId := c.Param("channelId")
-	data, err := h.srv.GetAttachmentsByChannel(channelId)
+	claims := c.Get("user").(*types.Claims)
+	data, err := h.srv.GetAttachmentsByChannel(claims.ID, channelId)
+	if errors.Is(err, services.ErrChannelNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -64,6 +107,8 @@ func (h *messageHandler) SendMessage(c echo.Context) error {
 	if err := c.Bind(&data); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	claims := c.Get("user").(*types.Claims)
+	data.SenderID = claims.ID
 	message, err := h.srv.SendMessage(data)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -75,12 +120,92 @@ func (h *messageHandler) DeleteMessage(c echo.Context) error {
 	messageId := c.Param("messageId")
 	claims := c.Get("user").(*types.Claims)
 
-	err := h.srv.DeleteMessage(claims.ID, messageId)
+	tombstone, err := h.srv.DeleteMessage(claims.ID, messageId)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	if tombstone != nil {
+		return c.JSON(http.StatusOK, tombstone)
+	}
 	return c.JSON(http.StatusOK, "Message deleted successfully")
 }
+func (h *messageHandler) EditMessage(c echo.Context) error {
+	messageId := c.Param("messageId")
+	claims := c.Get("user").(*types.Claims)
+
+	var data types.EditMessageD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if data.Content == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "content is required")
+	}
+
+	message, mentioned, err := h.srv.EditMessage(claims.ID, messageId, data.Content)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := ws.SendEditedMessage(message, mentioned); err != nil {
+		logger.LogError().Msgf("Failed to send edit of message %s: %v", message.ID, err)
+	}
+	return c.JSON(http.StatusOK, message)
+}
+
+func (h *messageHandler) GetMessageEdits(c echo.Context) error {
+	messageId := c.Param("messageId")
+	claims := c.Get("user").(*types.Claims)
+	data, err := h.srv.GetMessageEdits(claims.ID, messageId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+func (h *messageHandler) AddReaction(c echo.Context) error {
+	messageId := c.Param("messageId")
+	claims := c.Get("user").(*types.Claims)
+
+	var data types.ReactionD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if data.Emoji == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "emoji is required")
+	}
+
+	reaction, err := h.srv.AddReaction(claims.ID, messageId, data.Emoji)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, reaction)
+}
+
+func (h *messageHandler) RemoveReaction(c echo.Context) error {
+	messageId := c.Param("messageId")
+	claims := c.Get("user").(*types.Claims)
+
+	emoji := c.QueryParam("emoji")
+	if emoji == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "emoji is required")
+	}
+
+	_, err := h.srv.RemoveReaction(claims.ID, messageId, emoji)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, "Reaction removed successfully")
+}
+
+func (h *messageHandler) GetReactions(c echo.Context) error {
+	messageId := c.Param("messageId")
+	claims := c.Get("user").(*types.Claims)
+	data, err := h.srv.GetReactions(claims.ID, messageId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
 func (h *messageHandler) DeleteAttachment(c echo.Context) error {
 	attachmentID := c.Param("attachmentId")
 	claims := c.Get("user").(*types.Claims)
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/pkg/mail"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/labstack/echo/v4"
+)
+
+type notificationHandler struct {
+	srv    *services.NotificationService
+	prefs  *services.NotificationPreferenceService
+	digest *services.DigestService
+}
+
+func NewNotificationHandler() *notificationHandler {
+	return &notificationHandler{
+		srv:    services.NewNotificationService(),
+		prefs:  services.NewNotificationPreferenceService(),
+		digest: services.NewDigestService(),
+	}
+}
+
+func (h *notificationHandler) ListNotifications(c echo.Context) error {
+	claims := c.Get("user").(*types.Claims)
+	page, err := strconv.Atoi(c.QueryParam("p"))
+	if err != nil {
+		page = 1
+	}
+	unreadOnly := c.QueryParam("unread") == "true"
+
+	data, err := h.srv.ListNotifications(claims.ID, page, unreadOnly)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+func (h *notificationHandler) MarkRead(c echo.Context) error {
+	notificationID := c.Param("notificationId")
+	claims := c.Get("user").(*types.Claims)
+
+	notification, err := h.srv.MarkRead(claims.ID, notificationID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "notification not found")
+	}
+	return c.JSON(http.StatusOK, notification)
+}
+
+func (h *notificationHandler) MarkAllRead(c echo.Context) error {
+	claims := c.Get("user").(*types.Claims)
+
+	count, err := h.srv.MarkAllRead(claims.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]int{"updated": count})
+}
+
+func (h *notificationHandler) GetPreferences(c echo.Context) error {
+	claims := c.Get("user").(*types.Claims)
+
+	prefs, err := h.prefs.GetPreferences(claims.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, prefs)
+}
+
+func (h *notificationHandler) SetPreference(c echo.Context) error {
+	var data types.NotificationPreferenceD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+
+	pref, err := h.prefs.SetPreference(claims.ID, data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, pref)
+}
+
+func (h *notificationHandler) ResetPreference(c echo.Context) error {
+	claims := c.Get("user").(*types.Claims)
+
+	err := h.prefs.ResetPreference(claims.ID, c.QueryParam("workspaceId"), c.QueryParam("channelId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *notificationHandler) SetQuietHours(c echo.Context) error {
+	var data types.QuietHoursD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+
+	quiet, err := h.prefs.SetQuietHours(claims.ID, data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, quiet)
+}
+
+func (h *notificationHandler) GetDigestSettings(c echo.Context) error {
+	claims := c.Get("user").(*types.Claims)
+
+	settings, err := h.digest.GetSettings(claims.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+func (h *notificationHandler) UpdateDigestSettings(c echo.Context) error {
+	var data types.DigestSettingsD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+
+	settings, err := h.digest.UpdateSettings(claims.ID, data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+// PreviewDigest renders the digest the user would get now, as HTML or as
+// plain text with ?format=text.
+func (h *notificationHandler) PreviewDigest(c echo.Context) error {
+	claims := c.Get("user").(*types.Claims)
+	since, now, until := h.digestPeriod(claims.ID)
+
+	digest, err := h.digest.BuildDigest(claims.ID, since, now, until)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	msg, err := mail.Render(mail.TemplateDigest, digest)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if c.QueryParam("format") == "text" {
+		return c.String(http.StatusOK, msg.Text)
+	}
+	return c.HTML(http.StatusOK, msg.HTML)
+}
+
+// SendDigest emails the user their digest right away, without moving their
+// schedule.
+func (h *notificationHandler) SendDigest(c echo.Context) error {
+	claims := c.Get("user").(*types.Claims)
+	since, now, until := h.digestPeriod(claims.ID)
+
+	sent, err := h.digest.SendDigest(claims.ID, since, now, until)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"sent": sent})
+}
+
+// digestPeriod covers the last day, or since the last digest when there was
+// one, and the events of the next day.
+func (h *notificationHandler) digestPeriod(userID string) (time.Time, time.Time, time.Time) {
+	now := time.Now()
+	since := now.Add(-24 * time.Hour)
+	if settings, err := h.digest.GetSettings(userID); err == nil {
+		if last, ok := settings.LastSentAt(); ok {
+			since = last
+		}
+	}
+	return since, now, now.Add(24 * time.Hour)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/internal/ws"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/labstack/echo/v4"
+)
+
+type presenceHandler struct {
+	srv *services.PresenceService
+}
+
+func NewPresenceHandler() *presenceHandler {
+	return &presenceHandler{
+		srv: services.NewPresenceService(),
+	}
+}
+
+func (h *presenceHandler) GetPresences(c echo.Context) error {
+	workspaceId := c.Param("workspaceId")
+
+	users, err := h.srv.GetPresences(workspaceId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	statuses := make(map[string]ws.PresenceState, len(users))
+	for _, user := range users {
+		statuses[user.UserID] = ws.PresenceState{
+			Presence:   string(user.Presence),
+			StatusText: user.StatusText,
+		}
+	}
+	return c.JSON(http.StatusOK, statuses)
+}
+
+func (h *presenceHandler) SetPresence(c echo.Context) error {
+	workspaceId := c.Param("workspaceId")
+	claims := c.Get("user").(*types.Claims)
+
+	var data types.PresenceD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	user, err := h.srv.SetPresence(claims.ID, workspaceId, data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	ws.BroadcastPresence(workspaceId, claims.ID, string(user.Presence), user.StatusText)
+
+	return c.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/labstack/echo/v4"
+)
+
+type scheduledHandler struct {
+	srv *services.ScheduledMessageService
+}
+
+func NewScheduledHandler() *scheduledHandler {
+	return &scheduledHandler{
+		srv: services.NewScheduledMessageService(),
+	}
+}
+
+func (h *scheduledHandler) ScheduleMessage(c echo.Context) error {
+	var data types.ScheduledMessageD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+
+	scheduled, err := h.srv.ScheduleMessage(claims.ID, data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusCreated, scheduled)
+}
+
+func (h *scheduledHandler) CreateReminder(c echo.Context) error {
+	var data types.ReminderD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+
+	reminder, err := h.srv.CreateReminder(claims.ID, data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusCreated, reminder)
+}
+
+func (h *scheduledHandler) ListPending(c echo.Context) error {
+	claims := c.Get("user").(*types.Claims)
+
+	scheduled, err := h.srv.ListPending(claims.ID, c.QueryParam("kind"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, scheduled)
+}
+
+func (h *scheduledHandler) UpdateScheduled(c echo.Context) error {
+	scheduledID := c.Param("scheduledId")
+	var data types.EditScheduledD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+
+	scheduled, err := h.srv.UpdateScheduled(claims.ID, scheduledID, data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, scheduled)
+}
+
+func (h *scheduledHandler) CancelScheduled(c echo.Context) error {
+	scheduledID := c.Param("scheduledId")
+	claims := c.Get("user").(*types.Claims)
+
+	scheduled, err := h.srv.CancelScheduled(claims.ID, scheduledID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, scheduled)
+}
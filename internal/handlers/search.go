@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+	"github.com/labstack/echo/v4"
+)
+
+type searchHandler struct {
+	srv    *services.SearchService
+	wrkSrv *services.WorkspaceService
+}
+
+func NewSearchHandler() *searchHandler {
+	return &searchHandler{
+		srv:    services.NewSearchService(),
+		wrkSrv: services.NewWorkspaceService(),
+	}
+}
+
+func (h *searchHandler) SearchMessages(c echo.Context) error {
+	workspaceId := c.Param("workspaceId")
+	claims := c.Get("user").(*types.Claims)
+
+	params := types.SearchD{
+		Query:     c.QueryParam("q"),
+		ChannelID: c.QueryParam("channelId"),
+		SenderID:  c.QueryParam("senderId"),
+	}
+	if params.Query == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "q is required")
+	}
+
+	var err error
+	if after := c.QueryParam("after"); after != "" {
+		if params.After, err = time.Parse(time.RFC3339, after); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "after must be an RFC3339 date")
+		}
+	}
+	if before := c.QueryParam("before"); before != "" {
+		if params.Before, err = time.Parse(time.RFC3339, before); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "before must be an RFC3339 date")
+		}
+	}
+	if hasAttachment := c.QueryParam("hasAttachment"); hasAttachment != "" {
+		value, err := strconv.ParseBool(hasAttachment)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "hasAttachment must be a boolean")
+		}
+		params.HasAttachment = &value
+	}
+	if pinned := c.QueryParam("pinned"); pinned != "" {
+		value, err := strconv.ParseBool(pinned)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "pinned must be a boolean")
+		}
+		params.IsPinned = &value
+	}
+	if limit, err := strconv.Atoi(c.QueryParam("limit")); err == nil {
+		params.Limit = limit
+	}
+	if offset, err := strconv.Atoi(c.QueryParam("offset")); err == nil {
+		params.Offset = offset
+	}
+	if params.Limit < 0 || params.Offset < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit and offset can't be negative")
+	}
+
+	result, err := h.srv.SearchMessages(claims.ID, workspaceId, params)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// Reindex rebuilds the workspace's messages in the search index of every
+// replica, in the background.
+func (h *searchHandler) Reindex(c echo.Context) error {
+	workspaceId := c.Param("workspaceId")
+	claims := c.Get("user").(*types.Claims)
+
+	isAdmin, err := h.wrkSrv.CanUserPerformAction(claims.ID, workspaceId, db.UserRoleAdmin)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "you are not authorized to perform this action")
+	}
+
+	if err := h.srv.RequestReindex(workspaceId); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusAccepted)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+	"github.com/labstack/echo/v4"
+)
+
+type webhookHandler struct {
+	srv    *services.WebhookService
+	wrkSrv *services.WorkspaceService
+}
+
+func NewWebhookHandler() *webhookHandler {
+	return &webhookHandler{
+		srv:    services.NewWebhookService(),
+		wrkSrv: services.NewWorkspaceService(),
+	}
+}
+
+func (h *webhookHandler) CreateWebhook(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	var data types.WebhookD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	webhook, err := h.srv.CreateWebhook(claims.ID, workspaceID, data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusCreated, webhook)
+}
+
+func (h *webhookHandler) ListWebhooks(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	webhooks, err := h.srv.ListWebhooks(workspaceID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+func (h *webhookHandler) UpdateWebhook(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	webhookID := c.Param("webhookId")
+	var data types.WebhookD
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	webhook, err := h.srv.UpdateWebhook(workspaceID, webhookID, data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, webhook)
+}
+
+func (h *webhookHandler) DeleteWebhook(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	webhookID := c.Param("webhookId")
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	if err := h.srv.DeleteWebhook(workspaceID, webhookID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *webhookHandler) ListDeliveries(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	webhookID := c.Param("webhookId")
+	page, err := strconv.Atoi(c.QueryParam("p"))
+	if err != nil {
+		page = 1
+	}
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	deliveries, err := h.srv.ListDeliveries(workspaceID, webhookID, page)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+func (h *webhookHandler) Redeliver(c echo.Context) error {
+	workspaceID := c.Param("workspaceId")
+	webhookID := c.Param("webhookId")
+	deliveryID := c.Param("deliveryId")
+	claims := c.Get("user").(*types.Claims)
+	if err := h.requireAdmin(claims.ID, workspaceID); err != nil {
+		return err
+	}
+
+	delivery, err := h.srv.Redeliver(workspaceID, webhookID, deliveryID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusAccepted, delivery)
+}
+
+func (h *webhookHandler) requireAdmin(userID, workspaceID string) error {
+	isAdmin, err := h.wrkSrv.CanUserPerformAction(userID, workspaceID, db.UserRoleAdmin)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "you are not authorized to perform this action")
+	}
+	return nil
+}
@@ -197,7 +197,8 @@ func (h *workspaceHandler) AcceptInvitation(c echo.Context) error {
 
 func (h *workspaceHandler) GetWorkspaceChannels(c echo.Context) error {
 	workspaceId := c.Param("workspaceId")
-	data, err := h.csrv.ListChannelsByWorkspace(workspaceId)
+	claims := c.Get("user").(*types.Claims)
+	data, err := h.csrv.ListChannelsByWorkspace(claims.ID, workspaceId, c.QueryParam("archived") == "true")
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -2,37 +2,99 @@ package middlewares
 
 import (
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/CollabTED/CollabTed-Backend/config"
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
 	"github.com/CollabTED/CollabTed-Backend/pkg/types"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
+// AuthMiddleware authenticates the request with an "Authorization: Bearer"
+// header, falling back to the jwt cookie set at login. The header may also
+// carry a bot token, see BotAuth.
 func AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		cookie, err := c.Cookie("jwt")
-		if err != nil {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Missing or invalid cookie")
-		}
-
-		tokenString := cookie.Value
+		tokenString := requestToken(c)
 		if tokenString == "" {
 			return echo.NewHTTPError(http.StatusUnauthorized, "Missing token")
 		}
-		token, err := jwt.ParseWithClaims(tokenString, &types.Claims{}, func(token *jwt.Token) (any, error) {
-			return []byte(config.JWT_SECRET), nil
-		})
+		if strings.HasPrefix(tokenString, services.BotTokenPrefix) {
+			return BotAuth(c, tokenString, next)
+		}
+
+		claims, err := ParseToken(tokenString)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
 		}
 
-		claims, ok := token.Claims.(*types.Claims)
-		if !ok || !token.Valid {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+		c.Set("user", claims)
+		return next(c)
+	}
+}
+
+// OperatorMiddleware lets only the users listed in OPERATOR_IDS through. It
+// runs after AuthMiddleware.
+func OperatorMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := c.Get("user").(*types.Claims)
+		if !slices.Contains(config.OPERATOR_IDS, claims.ID) {
+			return echo.NewHTTPError(http.StatusForbidden, "you are not authorized to perform this action")
+		}
+		return next(c)
+	}
+}
+
+// StreamAuthMiddleware authenticates WebSocket and SSE connections. On top of
+// what AuthMiddleware accepts, it takes a one-time ?ticket= since browsers
+// can't set headers on those connections.
+func StreamAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ticket := c.QueryParam("ticket")
+		if ticket == "" {
+			return AuthMiddleware(next)(c)
+		}
+
+		claims, err := services.NewTicketService().RedeemTicket(ticket)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 		}
 
 		c.Set("user", claims)
 		return next(c)
 	}
 }
+
+// ParseToken verifies a JWT issued at login and returns its claims.
+func ParseToken(tokenString string) (*types.Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &types.Claims{}, func(token *jwt.Token) (any, error) {
+		return []byte(config.JWT_SECRET), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*types.Claims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+func requestToken(c echo.Context) string {
+	if header := c.Request().Header.Get(echo.HeaderAuthorization); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+
+	cookie, err := c.Cookie("jwt")
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
@@ -0,0 +1,217 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/labstack/echo/v4"
+)
+
+// tokenResources maps the routes bot tokens may call, by prefix under
+// /api/v1, to the resource their scopes are named after. Any other route
+// is only open to signed in users.
+var tokenResources = []struct {
+	prefix   string
+	resource string
+}{
+	{"/channels/:channelId/incoming-webhooks", ""},
+	{"/workspaces/:workspaceId/search/reindex", ""},
+	{"/messages", "messages"},
+	{"/scheduled", "messages"},
+	{"/workspaces/:workspaceId/search", "messages"},
+	{"/workspaces/:workspaceId/mentions", "messages"},
+	{"/channels", "channels"},
+	{"/tasks", "tasks"},
+	{"/boards", "tasks"},
+	{"/statuses", "tasks"},
+	{"/projects", "projects"},
+	{"/events", "events"},
+}
+
+// BotAuth authenticates a request made with a bot token. The token must
+// hold the scope of the route and can't reach another workspace than its
+// own, whether the route names it or a channel, message, task or other
+// resource of it. Every request, allowed or not, goes to the bot's audit log.
+func BotAuth(c echo.Context, token string, next echo.HandlerFunc) error {
+	srv := services.NewBotService()
+	claims, err := srv.Authenticate(token)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+	}
+
+	err = authorizeBot(c, claims)
+	if err == nil {
+		c.Set("user", claims)
+		err = next(c)
+	}
+
+	status := c.Response().Status
+	if he, ok := err.(*echo.HTTPError); ok {
+		status = he.Code
+	} else if err != nil && !c.Response().Committed {
+		status = http.StatusInternalServerError
+	}
+	req := c.Request()
+	go srv.RecordUse(claims, req.Method, c.Path(), req.URL.Path, status, c.RealIP())
+	return err
+}
+
+func authorizeBot(c echo.Context, claims *types.Claims) error {
+	scope := tokenScope(c.Request().Method, c.Path())
+	if scope == "" {
+		return echo.NewHTTPError(http.StatusForbidden, "this route can't be called with a bot token")
+	}
+	if !slices.Contains(claims.Scopes, scope) {
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("token is missing the %s scope", scope))
+	}
+
+	refs, err := resourceRefs(c)
+	if errors.Is(err, errBotBody) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	srv := services.NewBotService()
+	for _, ref := range refs {
+		workspaceID, err := srv.ResourceWorkspace(ref.kind, ref.id)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, ref.kind+" not found")
+		}
+		if workspaceID != claims.WorkspaceID {
+			return echo.NewHTTPError(http.StatusForbidden, "token can't access this workspace")
+		}
+	}
+	return nil
+}
+
+// resourceFields maps the route parameters, query parameters and JSON body
+// fields that name a resource to its kind, a field may also hold a list of
+// them. A ":id" parameter names the resource of its route.
+var resourceFields = map[string]string{
+	"workspaceId": services.BotResourceWorkspace,
+	"workspaceID": services.BotResourceWorkspace,
+	"channelId":   services.BotResourceChannel,
+	"channelID":   services.BotResourceChannel,
+	"messageId":   services.BotResourceMessage,
+	"messageID":   services.BotResourceMessage,
+	"parentId":    services.BotResourceMessage,
+	"parentID":    services.BotResourceMessage,
+	"scheduledId": services.BotResourceScheduled,
+	"projectId":   services.BotResourceProject,
+	"projectID":   services.BotResourceProject,
+	"taskId":      services.BotResourceTask,
+	"statusId":    services.BotResourceStatus,
+	"boardId":     services.BotResourceBoard,
+	"eventId":     services.BotResourceEvent,
+	// user workspaces
+	"userWorkspaceId":  services.BotResourceMember,
+	"userWorkspaceID":  services.BotResourceMember,
+	"userWorkspaceIds": services.BotResourceMember,
+	"assigneesIds":     services.BotResourceMember,
+	"assineesIds":      services.BotResourceMember,
+	"participantsIds":  services.BotResourceMember,
+}
+
+// errBotBody rejects the bodies resourceRefs can't read. Echo binds form and
+// XML bodies too, which would otherwise name resources unchecked.
+var errBotBody = errors.New("requests made with a bot token must send a JSON body")
+
+type resourceRef struct {
+	kind string
+	id   string
+}
+
+// resourceRefs lists the resources the request names, in its path, its
+// query and its body, so that each can be checked against the token's
+// workspace. Only JSON bodies are read, any other one is refused.
+func resourceRefs(c echo.Context) ([]resourceRef, error) {
+	var refs []resourceRef
+	for i, name := range c.ParamNames() {
+		value := c.ParamValues()[i]
+		kind, ok := resourceFields[name]
+		if name == "id" && strings.HasPrefix(strings.TrimPrefix(c.Path(), "/api/v1"), "/tasks/") {
+			kind, ok = services.BotResourceTask, true
+		}
+		if ok && value != "" {
+			refs = append(refs, resourceRef{kind, value})
+		}
+	}
+	for name, values := range c.QueryParams() {
+		if kind, ok := resourceFields[name]; ok {
+			refs = appendRefs(refs, kind, values...)
+		}
+	}
+
+	req := c.Request()
+	if req.Body == nil {
+		return refs, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	// the handler binds the body again
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		return refs, nil
+	}
+	if !strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		return nil, errBotBody
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("invalid JSON body: %v", err)
+	}
+	for name, raw := range fields {
+		kind, ok := resourceFields[name]
+		if !ok {
+			continue
+		}
+		var value string
+		var values []string
+		if json.Unmarshal(raw, &value) == nil {
+			refs = appendRefs(refs, kind, value)
+		} else if json.Unmarshal(raw, &values) == nil {
+			refs = appendRefs(refs, kind, values...)
+		}
+	}
+	return refs, nil
+}
+
+func appendRefs(refs []resourceRef, kind string, ids ...string) []resourceRef {
+	for _, id := range ids {
+		if id != "" {
+			refs = append(refs, resourceRef{kind, id})
+		}
+	}
+	return refs
+}
+
+// tokenScope returns the scope a token needs to call the route, or "" when
+// tokens can't call it.
+func tokenScope(method, route string) string {
+	route = strings.TrimPrefix(route, "/api/v1")
+	for _, r := range tokenResources {
+		if route != r.prefix && !strings.HasPrefix(route, r.prefix+"/") {
+			continue
+		}
+		if r.resource == "" {
+			return ""
+		}
+		if method == http.MethodGet {
+			return r.resource + ":read"
+		}
+		return r.resource + ":write"
+	}
+	return ""
+}
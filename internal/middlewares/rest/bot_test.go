@@ -0,0 +1,95 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/labstack/echo/v4"
+)
+
+func TestTokenScope(t *testing.T) {
+	tests := []struct {
+		method string
+		route  string
+		want   string
+	}{
+		{http.MethodPost, "/api/v1/messages/", "messages:write"},
+		{http.MethodGet, "/api/v1/messages/:channelId", "messages:read"},
+		{http.MethodDelete, "/api/v1/messages/:messageId", "messages:write"},
+		{http.MethodGet, "/api/v1/scheduled/", "messages:read"},
+		{http.MethodPatch, "/api/v1/scheduled/:scheduledId", "messages:write"},
+		{http.MethodGet, "/api/v1/workspaces/:workspaceId/search", "messages:read"},
+		{http.MethodGet, "/api/v1/workspaces/:workspaceId/mentions", "messages:read"},
+		{http.MethodGet, "/api/v1/channels/:channelId", "channels:read"},
+		{http.MethodPost, "/api/v1/channels/participants/add", "channels:write"},
+		{http.MethodPatch, "/api/v1/tasks/:taskId/title", "tasks:write"},
+		{http.MethodPut, "/api/v1/boards/update/:boardId", "tasks:write"},
+		{http.MethodGet, "/api/v1/statuses/:statusId", "tasks:read"},
+		{http.MethodGet, "/api/v1/projects/project/:projectId", "projects:read"},
+		{http.MethodPost, "/api/v1/events/create", "events:write"},
+		// incoming webhooks are managed by users only
+		{http.MethodPost, "/api/v1/channels/:channelId/incoming-webhooks/", ""},
+		{http.MethodGet, "/api/v1/channels/:channelId/incoming-webhooks/", ""},
+		// a prefix only matches whole path segments
+		{http.MethodGet, "/api/v1/messagesfoo", ""},
+		{http.MethodGet, "/api/v1/workspaces/:workspaceId/webhooks/", ""},
+		{http.MethodGet, "/api/v1/workspaces/:workspaceId/bots/", ""},
+		{http.MethodPost, "/api/v1/workspaces/:workspaceId/search/reindex", ""},
+		{http.MethodGet, "/api/v1/notifications/", ""},
+	}
+	for _, tt := range tests {
+		if got := tokenScope(tt.method, tt.route); got != tt.want {
+			t.Errorf("tokenScope(%s, %s) = %q, want %q", tt.method, tt.route, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorizeBotRefusesFormBodies(t *testing.T) {
+	body := strings.NewReader("channelID=other-workspace-channel&content=hi")
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/messages/", body)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	c.SetPath("/api/v1/messages/")
+
+	claims := &types.Claims{WorkspaceID: "workspace", Scopes: []string{"messages:write"}}
+	err := authorizeBot(c, claims)
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != http.StatusForbidden {
+		t.Fatalf("authorizeBot() = %v, want a 403", err)
+	}
+}
+
+func TestResourceRefs(t *testing.T) {
+	body := `{"channelID":"c","content":"hi","assigneesIds":["m1","m2",""],"title":"t"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks/t1/assignees?workspaceId=w", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	c.SetPath("/api/v1/tasks/:id/assignees")
+	c.SetParamNames("id")
+	c.SetParamValues("t1")
+
+	refs, err := resourceRefs(c)
+	if err != nil {
+		t.Fatalf("resourceRefs() error = %v", err)
+	}
+	want := []resourceRef{
+		{services.BotResourceTask, "t1"},
+		{services.BotResourceWorkspace, "w"},
+		{services.BotResourceChannel, "c"},
+		{services.BotResourceMember, "m1"},
+		{services.BotResourceMember, "m2"},
+	}
+	if len(refs) != len(want) {
+		t.Fatalf("resourceRefs() = %v, want %v", refs, want)
+	}
+	for _, ref := range want {
+		if !slices.Contains(refs, ref) {
+			t.Errorf("resourceRefs() = %v, missing %v", refs, ref)
+		}
+	}
+}
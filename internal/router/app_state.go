@@ -11,4 +11,5 @@ func AppStateRouter(e *echo.Group) {
 	appState := e.Group("/app-state", middlewares.AuthMiddleware)
 	appState.GET("/:userworkspaceId", h.GetAppState)
 	appState.PATCH("/:userworkspaceId", h.UpdateAppState)
+	appState.GET("/reads/:channelId", h.GetChannelReads)
 }
@@ -15,6 +15,7 @@ func AuthRoutes(e *echo.Group) {
 	auth.GET("/check", h.CheckUser, middlewares.AuthMiddleware)
 	auth.GET("/me", h.Me, middlewares.AuthMiddleware)
 	auth.GET("/logout", h.Logout, middlewares.AuthMiddleware)
+	auth.POST("/ticket", h.IssueTicket, middlewares.AuthMiddleware)
 	auth.POST("/send-resset", h.SendRessetLink)
 	auth.POST("/reset-password", h.RessetPassword)
 }
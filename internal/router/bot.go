@@ -0,0 +1,20 @@
+package router
+
+import (
+	"github.com/CollabTED/CollabTed-Backend/internal/handlers"
+	middlewares "github.com/CollabTED/CollabTed-Backend/internal/middlewares/rest"
+	"github.com/labstack/echo/v4"
+)
+
+func BotRoutes(e *echo.Group) {
+	h := handlers.NewBotHandler()
+
+	bots := e.Group("/workspaces/:workspaceId/bots", middlewares.AuthMiddleware)
+	bots.GET("/", h.ListBots)
+	bots.POST("/", h.CreateBot)
+	bots.DELETE("/:botId", h.DeleteBot)
+	bots.GET("/:botId/tokens", h.ListTokens)
+	bots.POST("/:botId/tokens", h.CreateToken)
+	bots.DELETE("/:botId/tokens/:tokenId", h.RevokeToken)
+	bots.GET("/:botId/audit", h.ListAuditLog)
+}
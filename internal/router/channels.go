@@ -10,10 +10,19 @@ func ChannelsRoutes(e *echo.Group) {
 	h := handlers.NewChannelHandler()
 
 	channels := e.Group("/channels", middlewares.AuthMiddleware)
-	channels.GET("/:workspaceId", h.GetChannel)
+	channels.GET("/:channelId", h.GetChannel)
 	channels.DELETE("/:channelId", h.DeleteChannel)
 	channels.POST("/", h.CreateChannel)
 	channels.PATCH("/:channelId", h.EditChannelName)
 	channels.GET("/worksapce/:workspaceId", h.GetWorkspaceChannels)
 	channels.POST("/participants/add", h.AddParticipants)
+	channels.POST("/participants/remove", h.RemoveParticipants)
+	channels.GET("/browse/:workspaceId", h.GetJoinableChannels)
+	channels.PATCH("/:channelId/visibility", h.SetVisibility)
+	channels.POST("/:channelId/join", h.JoinChannel)
+	channels.POST("/:channelId/leave", h.LeaveChannel)
+	channels.POST("/:channelId/archive", h.ArchiveChannel)
+	channels.POST("/:channelId/unarchive", h.UnarchiveChannel)
+	channels.POST("/direct", h.GetOrCreateDirectChannel)
+	channels.GET("/direct/:workspaceId", h.GetDirectChannels)
 }
@@ -0,0 +1,19 @@
+package router
+
+import (
+	"github.com/CollabTED/CollabTed-Backend/internal/handlers"
+	middlewares "github.com/CollabTED/CollabTed-Backend/internal/middlewares/rest"
+	"github.com/labstack/echo/v4"
+)
+
+func IncomingWebhookRoutes(e *echo.Group) {
+	h := handlers.NewIncomingWebhookHandler()
+
+	webhooks := e.Group("/channels/:channelId/incoming-webhooks", middlewares.AuthMiddleware)
+	webhooks.GET("/", h.ListIncomingWebhooks)
+	webhooks.POST("/", h.CreateIncomingWebhook)
+	webhooks.DELETE("/:webhookId", h.DeleteIncomingWebhook)
+
+	// authenticated by the token in the URL
+	e.POST("/hooks/:webhookId/:token", h.PostMessage)
+}
@@ -12,9 +12,17 @@ func MessageRoutes(e *echo.Group) {
 	messages.POST("/", h.SendMessage)
 	messages.POST("/pin/:messageId", h.PinMessage)
 	messages.GET("/:channelId/pinned", h.GetPinnedMessages)
+	messages.GET("/:messageId/thread", h.GetThread)
 	messages.GET("/:channelId", h.GetMessages)
 	messages.GET("/attachments/:channelId", h.GetAttachments)
+	messages.PATCH("/:messageId", h.EditMessage)
+	messages.GET("/:messageId/edits", h.GetMessageEdits)
 	messages.DELETE("/:messageId", h.DeleteMessage)
+	messages.GET("/:messageId/reactions", h.GetReactions)
+	messages.POST("/:messageId/reactions", h.AddReaction)
+	messages.DELETE("/:messageId/reactions", h.RemoveReaction)
 	messages.POST("/attachment", h.UploadAttachment)
 	messages.DELETE("/attachment/:id", h.DeleteAttachment)
+
+	e.GET("/workspaces/:workspaceId/mentions", h.GetMentions, middlewares.AuthMiddleware)
 }
@@ -0,0 +1,26 @@
+package router
+
+import (
+	"github.com/CollabTED/CollabTed-Backend/internal/handlers"
+	middlewares "github.com/CollabTED/CollabTed-Backend/internal/middlewares/rest"
+	"github.com/labstack/echo/v4"
+)
+
+func NotificationRoutes(e *echo.Group) {
+	h := handlers.NewNotificationHandler()
+
+	notifications := e.Group("/notifications", middlewares.AuthMiddleware)
+	notifications.GET("/", h.ListNotifications)
+	notifications.POST("/read-all", h.MarkAllRead)
+	notifications.PATCH("/:notificationId/read", h.MarkRead)
+
+	notifications.GET("/preferences", h.GetPreferences)
+	notifications.PUT("/preferences", h.SetPreference)
+	notifications.DELETE("/preferences", h.ResetPreference)
+	notifications.PUT("/quiet-hours", h.SetQuietHours)
+
+	notifications.GET("/digest", h.GetDigestSettings)
+	notifications.PUT("/digest", h.UpdateDigestSettings)
+	notifications.GET("/digest/preview", h.PreviewDigest)
+	notifications.POST("/digest/send", h.SendDigest)
+}
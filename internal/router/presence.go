@@ -0,0 +1,13 @@
+package router
+
+import (
+	"github.com/CollabTED/CollabTed-Backend/internal/handlers"
+	middlewares "github.com/CollabTED/CollabTed-Backend/internal/middlewares/rest"
+	"github.com/labstack/echo/v4"
+)
+
+func PresenceRoutes(e *echo.Group) {
+	h := handlers.NewPresenceHandler()
+	e.GET("/workspaces/:workspaceId/presence", h.GetPresences, middlewares.AuthMiddleware)
+	e.PATCH("/workspaces/:workspaceId/presence", h.SetPresence, middlewares.AuthMiddleware)
+}
@@ -21,9 +21,10 @@ func SetRoutes(e *echo.Echo) {
 		return c.String(http.StatusOK, "Server Working check the docs at /swagger/index.html or the graphql playground at /graphql")
 	})
 
-	e.GET("/ws", ws.WsChatHandler{}.Chat, middlewares.AuthMiddleware)
-	e.GET("/online", ws.WsChatHandler{}.Connections, middlewares.AuthMiddleware)
-	e.GET("/notifications", sse.NotificationHandler)
+	e.GET("/ws", ws.WsChatHandler{}.Chat, middlewares.StreamAuthMiddleware)
+	e.GET("/online", ws.WsChatHandler{}.Connections, middlewares.StreamAuthMiddleware)
+	e.GET("/ws/stats", ws.Stats, middlewares.AuthMiddleware, middlewares.OperatorMiddleware)
+	e.GET("/notifications", sse.NotificationHandler, middlewares.StreamAuthMiddleware)
 
 	v1 := e.Group("/api/v1")
 	AuthRoutes(v1)
@@ -42,4 +43,11 @@ func SetRoutes(e *echo.Echo) {
 	AppStateRouter(v1)
 	UserRequestRouter(v1)
 	SubscriptionRoutes(v1)
+	SearchRoutes(v1)
+	ScheduledRoutes(v1)
+	PresenceRoutes(v1)
+	NotificationRoutes(v1)
+	WebhookRoutes(v1)
+	IncomingWebhookRoutes(v1)
+	BotRoutes(v1)
 }
@@ -0,0 +1,18 @@
+package router
+
+import (
+	"github.com/CollabTED/CollabTed-Backend/internal/handlers"
+	middlewares "github.com/CollabTED/CollabTed-Backend/internal/middlewares/rest"
+	"github.com/labstack/echo/v4"
+)
+
+func ScheduledRoutes(e *echo.Group) {
+	h := handlers.NewScheduledHandler()
+
+	scheduled := e.Group("/scheduled", middlewares.AuthMiddleware)
+	scheduled.GET("/", h.ListPending)
+	scheduled.POST("/", h.ScheduleMessage)
+	scheduled.POST("/reminders", h.CreateReminder)
+	scheduled.PATCH("/:scheduledId", h.UpdateScheduled)
+	scheduled.DELETE("/:scheduledId", h.CancelScheduled)
+}
@@ -0,0 +1,13 @@
+package router
+
+import (
+	"github.com/CollabTED/CollabTed-Backend/internal/handlers"
+	middlewares "github.com/CollabTED/CollabTed-Backend/internal/middlewares/rest"
+	"github.com/labstack/echo/v4"
+)
+
+func SearchRoutes(e *echo.Group) {
+	h := handlers.NewSearchHandler()
+	e.GET("/workspaces/:workspaceId/search", h.SearchMessages, middlewares.AuthMiddleware)
+	e.POST("/workspaces/:workspaceId/search/reindex", h.Reindex, middlewares.AuthMiddleware)
+}
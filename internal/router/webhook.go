@@ -0,0 +1,19 @@
+package router
+
+import (
+	"github.com/CollabTED/CollabTed-Backend/internal/handlers"
+	middlewares "github.com/CollabTED/CollabTed-Backend/internal/middlewares/rest"
+	"github.com/labstack/echo/v4"
+)
+
+func WebhookRoutes(e *echo.Group) {
+	h := handlers.NewWebhookHandler()
+
+	webhooks := e.Group("/workspaces/:workspaceId/webhooks", middlewares.AuthMiddleware)
+	webhooks.GET("/", h.ListWebhooks)
+	webhooks.POST("/", h.CreateWebhook)
+	webhooks.PUT("/:webhookId", h.UpdateWebhook)
+	webhooks.DELETE("/:webhookId", h.DeleteWebhook)
+	webhooks.GET("/:webhookId/deliveries", h.ListDeliveries)
+	webhooks.POST("/:webhookId/deliveries/:deliveryId/redeliver", h.Redeliver)
+}
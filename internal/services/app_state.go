@@ -2,8 +2,11 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
 	"github.com/CollabTED/CollabTed-Backend/prisma"
 	db "github.com/CollabTED/CollabTed-Backend/prisma/db"
 )
@@ -17,77 +20,275 @@ func NewAppStateService() *AppStateService {
 func (s *AppStateService) CreateAppState(userWorkspaceId string) (*db.AppStateModel, error) {
 	appState, err := prisma.Client.AppState.CreateOne(
 		db.AppState.UserWorkspaceID.Set(userWorkspaceId),
-		db.AppState.UnreadChannels.Set([]string{}),
 	).Exec(
 		context.Background(),
 	)
 	return appState, err
 }
 
-func (s *AppStateService) GetAppState(userWorkspaceId string) (*db.AppStateModel, error) {
+// GetAppState returns the app state of a user workspace owned by the user
+// along with the unread and mention counts of every channel they participate
+// in.
+func (s *AppStateService) GetAppState(userID, userWorkspaceId string) (*types.AppState, error) {
+	if err := ownUserWorkspace(userID, userWorkspaceId); err != nil {
+		return nil, err
+	}
+
 	appState, err := prisma.Client.AppState.FindFirst(
 		db.AppState.UserWorkspaceID.Equals(userWorkspaceId),
 	).Exec(context.Background())
-	return appState, err
+	if err != nil {
+		return nil, err
+	}
+
+	unread, err := s.GetUnreadCounts(userWorkspaceId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.AppState{
+		UserWorkspaceId: appState.UserWorkspaceID,
+		MissedCalls:     appState.MissedCalls,
+		UnreadChannels:  unread,
+	}, nil
 }
 
-func (s *AppStateService) UpdateAppState(userWorkspaceId, action, value string) (*db.AppStateModel, error) {
-	ctx := context.Background()
+// UpdateAppState applies an action to the app state of a user workspace
+// owned by the user.
+func (s *AppStateService) UpdateAppState(userID, userWorkspaceId string, req types.AppStateUpdateRequest) (*types.AppState, error) {
+	if err := ownUserWorkspace(userID, userWorkspaceId); err != nil {
+		return nil, err
+	}
 
-	switch action {
-	case "append":
-		existing, err := prisma.Client.AppState.FindFirst(
-			db.AppState.UserWorkspaceID.Equals(userWorkspaceId),
-		).Exec(ctx)
+	switch req.Action {
+	case "read", "clear":
+		_, err := s.MarkChannelRead(userID, req.Value, req.MessageID)
 		if err != nil {
 			return nil, err
 		}
+		return s.GetAppState(userID, userWorkspaceId)
 
-		uniqueChannels := make(map[string]struct{})
-
-		if existing != nil {
-			for _, ch := range existing.UnreadChannels {
-				uniqueChannels[ch] = struct{}{}
-			}
-		}
+	default:
+		return nil, fmt.Errorf("invalid action: %s", req.Action)
+	}
+}
 
-		uniqueChannels[value] = struct{}{}
+// MarkChannelRead moves the user's read pointer in a channel they participate
+// in up to messageID, or to the latest message of the channel when messageID
+// is empty. The pointer never moves backwards. Marking an empty channel as
+// read does nothing and returns a nil pointer.
+func (s *AppStateService) MarkChannelRead(userID, channelID, messageID string) (*db.ChannelReadModel, error) {
+	ctx := context.Background()
 
-		newChannels := make([]string, 0, len(uniqueChannels))
-		for ch := range uniqueChannels {
-			newChannels = append(newChannels, ch)
-		}
+	_, user, err := channelParticipant(userID, channelID)
+	if err != nil {
+		return nil, err
+	}
+	userWorkspaceId := user.ID
 
-		appState, err := prisma.Client.AppState.FindUnique(
-			db.AppState.UserWorkspaceID.Equals(userWorkspaceId),
-		).Update(
-			db.AppState.UnreadChannels.Set(newChannels),
+	var message *db.MessageModel
+	if messageID != "" {
+		message, err = prisma.Client.Message.FindFirst(
+			db.Message.ID.Equals(messageID),
+			db.Message.ChannelID.Equals(channelID),
 		).Exec(ctx)
-		return appState, err
-
-	case "clear":
-		existing, err := prisma.Client.AppState.FindUnique(
-			db.AppState.UserWorkspaceID.Equals(userWorkspaceId),
+		if err != nil {
+			return nil, fmt.Errorf("message not found: %v", err)
+		}
+	} else {
+		message, err = prisma.Client.Message.FindFirst(
+			db.Message.ChannelID.Equals(channelID),
+		).OrderBy(
+			db.Message.CreatedAt.Order(db.SortOrderDesc),
 		).Exec(ctx)
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, nil
+		}
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		filtered := make([]string, 0, len(existing.UnreadChannels))
-		for _, ch := range existing.UnreadChannels {
-			if ch != value {
-				filtered = append(filtered, ch)
-			}
+	pointer := db.ChannelRead.UserWorkspaceIDChannelID(
+		db.ChannelRead.UserWorkspaceID.Equals(userWorkspaceId),
+		db.ChannelRead.ChannelID.Equals(channelID),
+	)
+	existing, err := prisma.Client.ChannelRead.FindUnique(pointer).Exec(ctx)
+	if errors.Is(err, db.ErrNotFound) {
+		read, err := prisma.Client.ChannelRead.CreateOne(
+			db.ChannelRead.UserWorkspaceID.Set(userWorkspaceId),
+			db.ChannelRead.ChannelID.Set(channelID),
+			db.ChannelRead.LastReadMessageID.Set(message.ID),
+			db.ChannelRead.LastReadAt.Set(message.CreatedAt),
+		).Exec(ctx)
+		if _, exists := db.IsErrUniqueConstraint(err); !exists {
+			return read, err
 		}
+		// created concurrently, move that pointer instead
+		existing, err = prisma.Client.ChannelRead.FindUnique(pointer).Exec(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		updated, err := prisma.Client.AppState.FindUnique(
-			db.AppState.UserWorkspaceID.Equals(userWorkspaceId),
-		).Update(
-			db.AppState.UnreadChannels.Set(filtered),
-		).Exec(ctx)
-		return updated, err
+	// only an older pointer is moved, even if another read moved it meanwhile
+	_, err = prisma.Client.ChannelRead.FindMany(
+		db.ChannelRead.ID.Equals(existing.ID),
+		db.ChannelRead.LastReadAt.Lt(message.CreatedAt),
+	).Update(
+		db.ChannelRead.LastReadMessageID.Set(message.ID),
+		db.ChannelRead.LastReadAt.Set(message.CreatedAt),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return prisma.Client.ChannelRead.FindUnique(
+		db.ChannelRead.ID.Equals(existing.ID),
+	).Exec(ctx)
+}
 
-	default:
-		return nil, fmt.Errorf("invalid action: %s", action)
+// GetChannelReads lists the read pointers of a channel, used to show who has
+// seen a message, if the user participates in it.
+func (s *AppStateService) GetChannelReads(userID, channelID string) ([]db.ChannelReadModel, error) {
+	if _, err := channelMember(userID, channelID); err != nil {
+		return nil, err
+	}
+
+	reads, err := prisma.Client.ChannelRead.FindMany(
+		db.ChannelRead.ChannelID.Equals(channelID),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return reads, nil
+}
+
+// GetUnreadCounts counts, for every channel the user participates in, the
+// messages from others newer than the user's read pointer and how many of
+// them mention the user. Without a pointer, messages since the user joined
+// the workspace count as unread.
+func (s *AppStateService) GetUnreadCounts(userWorkspaceId string) ([]types.ChannelUnread, error) {
+	ctx := context.Background()
+
+	user, err := prisma.Client.UserWorkspace.FindUnique(
+		db.UserWorkspace.ID.Equals(userWorkspaceId),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := prisma.Client.Channel.FindMany(
+		db.Channel.ParticipantsIDS.Has(userWorkspaceId),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reads, err := prisma.Client.ChannelRead.FindMany(
+		db.ChannelRead.UserWorkspaceID.Equals(userWorkspaceId),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	readByChannel := make(map[string]db.ChannelReadModel, len(reads))
+	for _, read := range reads {
+		readByChannel[read.ChannelID] = read
+	}
+
+	result := make([]types.ChannelUnread, 0, len(channels))
+	for _, channel := range channels {
+		unread := types.ChannelUnread{
+			ChannelID:  channel.ID,
+			LastReadAt: user.JoinedAt,
+		}
+		if read, ok := readByChannel[channel.ID]; ok {
+			unread.LastReadMessageID = read.LastReadMessageID
+			unread.LastReadAt = read.LastReadAt
+		}
+		result = append(result, unread)
+	}
+
+	counts, err := countUnread(user, result)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		count := counts[result[i].ChannelID]
+		result[i].UnreadCount = count.Unread
+		result[i].MentionCount = count.Mentions
+	}
+
+	return result, nil
+}
+
+type unreadCount struct {
+	ID       objectID `json:"_id"`
+	Unread   int      `json:"unread"`
+	Mentions int      `json:"mentions"`
+}
+
+// countUnread counts the unread messages and mentions of every channel in a
+// single aggregation, grouped by channel ID.
+func countUnread(user *db.UserWorkspaceModel, channels []types.ChannelUnread) (map[string]unreadCount, error) {
+	counts := make(map[string]unreadCount, len(channels))
+	if len(channels) == 0 {
+		return counts, nil
+	}
+
+	newer := make([]map[string]any, 0, len(channels))
+	for _, channel := range channels {
+		newer = append(newer, map[string]any{
+			"channelID": objectID{channel.ChannelID},
+			"createdAt": map[string]any{"$gt": mongoDate{channel.LastReadAt}},
+		})
+	}
+	mentioned := map[string]any{
+		"$in": []any{objectID{user.ID}, map[string]any{"$ifNull": []any{"$mentionIds", []any{}}}},
+	}
+
+	cmd, err := json.Marshal(map[string]any{
+		"aggregate": "Message",
+		"pipeline": []map[string]any{
+			{"$match": map[string]any{
+				"$or":       newer,
+				"senderID":  map[string]any{"$ne": objectID{user.UserID}},
+				"isDeleted": map[string]any{"$ne": true},
+			}},
+			{"$group": map[string]any{
+				"_id":      "$channelID",
+				"unread":   map[string]any{"$sum": 1},
+				"mentions": map[string]any{"$sum": map[string]any{"$cond": []any{mentioned, 1, 0}}},
+			}},
+		},
+		// one group per channel, all in the first batch
+		"cursor": map[string]any{"batchSize": len(channels)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		Cursor struct {
+			FirstBatch []unreadCount `json:"firstBatch"`
+		} `json:"cursor"`
+	}
+	if err := runCommand(cmd, &reply); err != nil {
+		return nil, err
+	}
+	for _, count := range reply.Cursor.FirstBatch {
+		counts[count.ID.OID] = count
+	}
+	return counts, nil
+}
+
+// ownUserWorkspace checks that the user workspace is the user's.
+func ownUserWorkspace(userID, userWorkspaceId string) error {
+	_, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.ID.Equals(userWorkspaceId),
+		db.UserWorkspace.UserID.Equals(userID),
+	).Exec(context.Background())
+	if errors.Is(err, db.ErrNotFound) {
+		return errors.New("user workspace not found")
 	}
+	return err
 }
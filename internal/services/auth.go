@@ -4,10 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/smtp"
 	"time"
 
 	"github.com/CollabTED/CollabTed-Backend/config"
+	"github.com/CollabTED/CollabTed-Backend/pkg/mail"
 	"github.com/CollabTED/CollabTed-Backend/pkg/redis"
 	"github.com/CollabTED/CollabTed-Backend/pkg/types"
 	"github.com/CollabTED/CollabTed-Backend/pkg/utils"
@@ -90,7 +90,6 @@ func (s *AuthService) CreateUser(name string, email string, password string, pro
 	// Create Personal AppState
 	_, err = prisma.Client.AppState.CreateOne(
 		db.AppState.UserWorkspaceID.Set(personalUserWorkspace.ID),
-		db.AppState.UnreadChannels.Set([]string{}),
 	).Exec(
 		context.Background(),
 	)
@@ -98,6 +97,11 @@ func (s *AuthService) CreateUser(name string, email string, password string, pro
 		return nil, err
 	}
 
+	// Daily digest by default
+	if _, err := NewDigestService().GetSettings(result.ID); err != nil {
+		return nil, err
+	}
+
 	return result, nil
 }
 
@@ -171,13 +175,12 @@ func (s *AuthService) SendRessetLink(email string) error {
 	user, err := prisma.Client.User.FindFirst(
 		db.User.Email.Equals(email),
 	).Exec(context.Background())
-
-	if user.IsOAuth {
-		return errors.New("user is registered with google oauth, please log in with google")
-	}
 	if err != nil {
 		return errors.New("no user found with this email")
 	}
+	if user.IsOAuth {
+		return errors.New("user is registered with google oauth, please log in with google")
+	}
 
 	// Generate a secure reset token
 	token, err := utils.GenerateResetToken(20)
@@ -195,23 +198,9 @@ func (s *AuthService) SendRessetLink(email string) error {
 	// Prepare the password reset link
 	link := fmt.Sprintf("%s/auth/password-reset?token=%s", config.HOST_URL, token)
 
-	// Prepare the email content
-	subject := "Password Reset Request"
-	body := fmt.Sprintf("Your password reset link is:\n\n%s\n\nThis link is valid for 1 hour.", link)
-	message := fmt.Sprintf("Subject: %s\r\n\r\n%s", subject, body)
-
-	// Email server configuration
-	smtpHost := config.EMAIL_HOST
-	smtpPort := config.EMAIL_PORT
-	auth := smtp.PlainAuth("", config.EMAIL, config.EMAIL_PASSWORD, smtpHost)
-
-	// Send the email
-	err = smtp.SendMail(smtpHost+":"+smtpPort, auth, config.EMAIL, []string{email}, []byte(message))
-	if err != nil {
+	if err := mail.Send(mail.TemplateReset, []string{email}, mail.LinkData{Link: link}); err != nil {
 		return err
 	}
-
-	fmt.Println("Password reset email sent to:", user.Email)
 	return nil
 }
 
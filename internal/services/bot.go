@@ -0,0 +1,481 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/pkg/utils"
+	"github.com/CollabTED/CollabTed-Backend/prisma"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+// Scopes a bot token can be granted. Read scopes cover GET requests, write
+// scopes everything else.
+const (
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeChannelsRead  = "channels:read"
+	ScopeChannelsWrite = "channels:write"
+	ScopeTasksRead     = "tasks:read"
+	ScopeTasksWrite    = "tasks:write"
+	ScopeProjectsRead  = "projects:read"
+	ScopeProjectsWrite = "projects:write"
+	ScopeEventsRead    = "events:read"
+	ScopeEventsWrite   = "events:write"
+)
+
+// BotTokenPrefix starts every bot token, which tells them apart from the
+// JWTs issued at login.
+const BotTokenPrefix = "ctd_"
+
+const botAuditPageSize = 50
+
+var botScopes = map[string]struct{}{
+	ScopeMessagesRead:  {},
+	ScopeMessagesWrite: {},
+	ScopeChannelsRead:  {},
+	ScopeChannelsWrite: {},
+	ScopeTasksRead:     {},
+	ScopeTasksWrite:    {},
+	ScopeProjectsRead:  {},
+	ScopeProjectsWrite: {},
+	ScopeEventsRead:    {},
+	ScopeEventsWrite:   {},
+}
+
+var ErrInvalidBotToken = errors.New("invalid bot token")
+
+// BotService manages the service accounts of a workspace. A bot is a member
+// of its workspace only, and authenticates with tokens limited to scopes;
+// every request made with a token is kept in an audit log.
+type BotService struct {
+	appStateSrv *AppStateService
+}
+
+func NewBotService() *BotService {
+	return &BotService{
+		appStateSrv: NewAppStateService(),
+	}
+}
+
+// CreatedBotToken carries a new token, which can't be recovered later.
+type CreatedBotToken struct {
+	*db.BotTokenModel
+	Token string `json:"token"`
+}
+
+// CreateBot creates the bot user and adds it to the workspace as a member.
+func (s *BotService) CreateBot(userID, workspaceID string, data types.BotD) (*db.BotModel, error) {
+	ctx := context.Background()
+
+	name := strings.TrimSpace(data.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	existingUsers, err := NewWorkspaceService().GetAllUsersInWorkspace(workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve users in workspace: %v", err)
+	}
+	name = utils.GenerateUniqueName(name, existingUsers)
+
+	botEmail, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	// not a bcrypt hash, so no password ever matches it
+	password, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := prisma.Client.User.CreateOne(
+		db.User.Email.Set("bot-"+botEmail+"@bots.collabted"),
+		db.User.Name.Set(name),
+		db.User.Password.Set(password),
+		db.User.ProfilePicture.Set(""),
+		db.User.Active.Set(false),
+		db.User.IsOAuth.Set(false),
+		db.User.IsBot.Set(true),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bot user: %v", err)
+	}
+
+	userWorkspace, err := prisma.Client.UserWorkspace.CreateOne(
+		db.UserWorkspace.User.Link(
+			db.User.ID.Equals(user.ID),
+		),
+		db.UserWorkspace.Workspace.Link(
+			db.Workspace.ID.Equals(workspaceID),
+		),
+		db.UserWorkspace.Role.Set(db.UserRoleMember),
+		db.UserWorkspace.JoinedAt.Set(time.Now()),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join workspace: %v", err)
+	}
+	if _, err := s.appStateSrv.CreateAppState(userWorkspace.ID); err != nil {
+		return nil, fmt.Errorf("failed to create app state: %v", err)
+	}
+
+	bot, err := prisma.Client.Bot.CreateOne(
+		db.Bot.WorkspaceID.Set(workspaceID),
+		db.Bot.CreatorID.Set(userID),
+		db.Bot.UserID.Set(user.ID),
+		db.Bot.Name.Set(name),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bot: %v", err)
+	}
+
+	go emitWebhook(workspaceID, WebhookMemberJoined, map[string]any{
+		"userId":          user.ID,
+		"userWorkspaceId": userWorkspace.ID,
+		"name":            name,
+		"role":            userWorkspace.Role,
+		"isBot":           true,
+	})
+	return bot, nil
+}
+
+func (s *BotService) ListBots(workspaceID string) ([]db.BotModel, error) {
+	return prisma.Client.Bot.FindMany(
+		db.Bot.WorkspaceID.Equals(workspaceID),
+	).OrderBy(
+		db.Bot.CreatedAt.Order(db.SortOrderDesc),
+	).Exec(context.Background())
+}
+
+// DeleteBot revokes the bot's tokens and removes it from the workspace. Its
+// user is kept as the author of what it already posted.
+func (s *BotService) DeleteBot(workspaceID, botID string) error {
+	ctx := context.Background()
+
+	bot, err := s.getBot(workspaceID, botID)
+	if err != nil {
+		return err
+	}
+
+	_, err = prisma.Client.BotToken.FindMany(
+		db.BotToken.BotID.Equals(bot.ID),
+	).Update(
+		db.BotToken.RevokedAt.Set(time.Now()),
+	).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to revoke tokens: %v", err)
+	}
+
+	if _, err := NewWorkspaceService().KickUser(workspaceID, bot.UserID); err != nil && !errors.Is(err, db.ErrNotFound) {
+		return fmt.Errorf("failed to remove bot from workspace: %v", err)
+	}
+
+	_, err = prisma.Client.Bot.FindUnique(
+		db.Bot.ID.Equals(bot.ID),
+	).Delete().Exec(ctx)
+	return err
+}
+
+// CreateToken issues a token acting as the bot with the given scopes.
+func (s *BotService) CreateToken(userID, workspaceID, botID string, data types.BotTokenD) (*CreatedBotToken, error) {
+	bot, err := s.getBot(workspaceID, botID)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(data.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if len(data.Scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	for _, scope := range data.Scopes {
+		if _, ok := botScopes[scope]; !ok {
+			return nil, fmt.Errorf("unknown scope: %s", scope)
+		}
+	}
+	if data.ExpiresAt != nil && !data.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("expiresAt must be in the future")
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	token := BotTokenPrefix + secret
+
+	created, err := prisma.Client.BotToken.CreateOne(
+		db.BotToken.BotID.Set(bot.ID),
+		db.BotToken.WorkspaceID.Set(workspaceID),
+		db.BotToken.CreatorID.Set(userID),
+		db.BotToken.Name.Set(name),
+		db.BotToken.Prefix.Set(token[:len(BotTokenPrefix)+8]),
+		db.BotToken.TokenHash.Set(hashToken(token)),
+		db.BotToken.Scopes.Set(data.Scopes),
+		db.BotToken.ExpiresAt.SetIfPresent(data.ExpiresAt),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token: %v", err)
+	}
+
+	created.TokenHash = ""
+	return &CreatedBotToken{BotTokenModel: created, Token: token}, nil
+}
+
+func (s *BotService) ListTokens(workspaceID, botID string) ([]db.BotTokenModel, error) {
+	if _, err := s.getBot(workspaceID, botID); err != nil {
+		return nil, err
+	}
+
+	tokens, err := prisma.Client.BotToken.FindMany(
+		db.BotToken.BotID.Equals(botID),
+	).OrderBy(
+		db.BotToken.CreatedAt.Order(db.SortOrderDesc),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for i := range tokens {
+		tokens[i].TokenHash = ""
+	}
+	return tokens, nil
+}
+
+// RevokeToken stops the token from authenticating. It stays listed, with
+// its audit log, for the record.
+func (s *BotService) RevokeToken(workspaceID, botID, tokenID string) (*db.BotTokenModel, error) {
+	ctx := context.Background()
+
+	if _, err := s.getBot(workspaceID, botID); err != nil {
+		return nil, err
+	}
+	token, err := prisma.Client.BotToken.FindFirst(
+		db.BotToken.ID.Equals(tokenID),
+		db.BotToken.BotID.Equals(botID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, errors.New("token not found")
+	}
+	if _, revoked := token.RevokedAt(); revoked {
+		return nil, errors.New("token is already revoked")
+	}
+
+	token, err = prisma.Client.BotToken.FindUnique(
+		db.BotToken.ID.Equals(token.ID),
+	).Update(
+		db.BotToken.RevokedAt.Set(time.Now()),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token.TokenHash = ""
+	return token, nil
+}
+
+// Authenticate returns the claims of the bot a token acts as, if the token
+// is neither revoked nor expired.
+func (s *BotService) Authenticate(token string) (*types.Claims, error) {
+	ctx := context.Background()
+
+	botToken, err := prisma.Client.BotToken.FindUnique(
+		db.BotToken.TokenHash.Equals(hashToken(token)),
+	).Exec(ctx)
+	if err != nil {
+		return nil, ErrInvalidBotToken
+	}
+	if _, revoked := botToken.RevokedAt(); revoked {
+		return nil, ErrInvalidBotToken
+	}
+	if expiresAt, ok := botToken.ExpiresAt(); ok && !expiresAt.After(time.Now()) {
+		return nil, ErrInvalidBotToken
+	}
+
+	bot, err := prisma.Client.Bot.FindUnique(
+		db.Bot.ID.Equals(botToken.BotID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, ErrInvalidBotToken
+	}
+	user, err := prisma.Client.User.FindUnique(
+		db.User.ID.Equals(bot.UserID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, ErrInvalidBotToken
+	}
+
+	return &types.Claims{
+		ID:             user.ID,
+		Name:           user.Name,
+		Email:          user.Email,
+		ProfilePicture: user.ProfilePicture,
+		TokenID:        botToken.ID,
+		WorkspaceID:    botToken.WorkspaceID,
+		Scopes:         botToken.Scopes,
+	}, nil
+}
+
+// Kinds of resources a bot token request may name, to find the workspace
+// they belong to.
+const (
+	BotResourceWorkspace = "workspace"
+	BotResourceChannel   = "channel"
+	BotResourceMessage   = "message"
+	BotResourceScheduled = "scheduled"
+	BotResourceProject   = "project"
+	BotResourceTask      = "task"
+	BotResourceStatus    = "status"
+	BotResourceBoard     = "board"
+	BotResourceEvent     = "event"
+	BotResourceMember    = "member"
+)
+
+// ResourceWorkspace returns the workspace the resource of the given kind
+// belongs to.
+func (s *BotService) ResourceWorkspace(kind, id string) (string, error) {
+	ctx := context.Background()
+
+	switch kind {
+	case BotResourceWorkspace:
+		return id, nil
+	case BotResourceChannel:
+		channel, err := prisma.Client.Channel.FindUnique(
+			db.Channel.ID.Equals(id),
+		).Exec(ctx)
+		if err != nil {
+			return "", err
+		}
+		return channel.WorkspaceID, nil
+	case BotResourceMessage:
+		message, err := prisma.Client.Message.FindUnique(
+			db.Message.ID.Equals(id),
+		).Exec(ctx)
+		if err != nil {
+			return "", err
+		}
+		return s.ResourceWorkspace(BotResourceChannel, message.ChannelID)
+	case BotResourceScheduled:
+		scheduled, err := prisma.Client.ScheduledMessage.FindUnique(
+			db.ScheduledMessage.ID.Equals(id),
+		).Exec(ctx)
+		if err != nil {
+			return "", err
+		}
+		return s.ResourceWorkspace(BotResourceChannel, scheduled.ChannelID)
+	case BotResourceProject:
+		project, err := prisma.Client.Project.FindUnique(
+			db.Project.ID.Equals(id),
+		).Exec(ctx)
+		if err != nil {
+			return "", err
+		}
+		return project.WorkspaceID, nil
+	case BotResourceTask:
+		task, err := prisma.Client.Task.FindUnique(
+			db.Task.ID.Equals(id),
+		).Exec(ctx)
+		if err != nil {
+			return "", err
+		}
+		return s.ResourceWorkspace(BotResourceProject, task.ProjectID)
+	case BotResourceStatus:
+		status, err := prisma.Client.Status.FindUnique(
+			db.Status.ID.Equals(id),
+		).Exec(ctx)
+		if err != nil {
+			return "", err
+		}
+		return s.ResourceWorkspace(BotResourceProject, status.ProjectID)
+	case BotResourceBoard:
+		board, err := prisma.Client.Board.FindUnique(
+			db.Board.ID.Equals(id),
+		).Exec(ctx)
+		if err != nil {
+			return "", err
+		}
+		return board.WorkspaceID, nil
+	case BotResourceEvent:
+		event, err := prisma.Client.Event.FindUnique(
+			db.Event.ID.Equals(id),
+		).Exec(ctx)
+		if err != nil {
+			return "", err
+		}
+		return event.WorkspaceID, nil
+	case BotResourceMember:
+		member, err := prisma.Client.UserWorkspace.FindUnique(
+			db.UserWorkspace.ID.Equals(id),
+		).Exec(ctx)
+		if err != nil {
+			return "", err
+		}
+		return member.WorkspaceID, nil
+	}
+	return "", fmt.Errorf("unknown resource: %s", kind)
+}
+
+// RecordUse adds a request made with a token to the audit log and marks the
+// token as used.
+func (s *BotService) RecordUse(claims *types.Claims, method, route, path string, status int, remoteAddr string) {
+	ctx := context.Background()
+
+	botToken, err := prisma.Client.BotToken.FindUnique(
+		db.BotToken.ID.Equals(claims.TokenID),
+	).Update(
+		db.BotToken.LastUsedAt.Set(time.Now()),
+	).Exec(ctx)
+	if err != nil {
+		logger.LogError().Msgf("Failed to mark bot token %s as used: %v", claims.TokenID, err)
+		return
+	}
+
+	_, err = prisma.Client.BotAuditLog.CreateOne(
+		db.BotAuditLog.TokenID.Set(botToken.ID),
+		db.BotAuditLog.BotID.Set(botToken.BotID),
+		db.BotAuditLog.WorkspaceID.Set(botToken.WorkspaceID),
+		db.BotAuditLog.Method.Set(method),
+		db.BotAuditLog.Route.Set(route),
+		db.BotAuditLog.Path.Set(path),
+		db.BotAuditLog.Status.Set(status),
+		db.BotAuditLog.RemoteAddr.Set(remoteAddr),
+	).Exec(ctx)
+	if err != nil {
+		logger.LogError().Msgf("Failed to audit request of bot token %s: %v", botToken.ID, err)
+	}
+}
+
+// ListAuditLog returns a page of the requests made by the bot, newest first,
+// optionally only those made with one of its tokens.
+func (s *BotService) ListAuditLog(workspaceID, botID, tokenID string, page int) ([]db.BotAuditLogModel, error) {
+	if _, err := s.getBot(workspaceID, botID); err != nil {
+		return nil, err
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	filters := []db.BotAuditLogWhereParam{
+		db.BotAuditLog.BotID.Equals(botID),
+	}
+	if tokenID != "" {
+		filters = append(filters, db.BotAuditLog.TokenID.Equals(tokenID))
+	}
+	return prisma.Client.BotAuditLog.FindMany(filters...).OrderBy(
+		db.BotAuditLog.CreatedAt.Order(db.SortOrderDesc),
+	).Skip((page - 1) * botAuditPageSize).Take(botAuditPageSize).Exec(context.Background())
+}
+
+func (s *BotService) getBot(workspaceID, botID string) (*db.BotModel, error) {
+	bot, err := prisma.Client.Bot.FindFirst(
+		db.Bot.ID.Equals(botID),
+		db.Bot.WorkspaceID.Equals(workspaceID),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, errors.New("bot not found")
+	}
+	return bot, nil
+}
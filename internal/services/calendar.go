@@ -50,6 +50,7 @@ func (s *EventService) CreateEvent(data types.EventD) (*db.EventModel, error) {
 			return nil, err
 		}
 	}
+	go emitWebhook(result.WorkspaceID, WebhookEventCreated, result)
 
 	return result, nil
 }
@@ -2,8 +2,12 @@ package services
 
 import (
 	"context"
-
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
 	"github.com/CollabTED/CollabTed-Backend/pkg/types"
@@ -13,12 +17,27 @@ import (
 
 type ChannelService struct{}
 
+// ErrChannelArchived is returned when writing to an archived channel.
+var ErrChannelArchived = errors.New("channel is archived")
+
+// ErrChannelNotFound is returned when a channel doesn't exist or is hidden
+// from the user.
+var ErrChannelNotFound = errors.New("channel not found")
+
 func NewChannelService() *ChannelService {
 	return &ChannelService{}
 }
 
 // CreateChannel creates a new channel in a workspace and adds existing participants.
 func (s *ChannelService) CreateChannel(data types.ChannelD) (*db.ChannelModel, error) {
+	kind := db.ChannelKindPublic
+	if data.Kind != "" {
+		kind = db.ChannelKind(data.Kind)
+	}
+	if kind != db.ChannelKindPublic && kind != db.ChannelKindPrivate {
+		return nil, fmt.Errorf("invalid channel kind: %s", data.Kind)
+	}
+
 	// Create a new channel
 	result, err := prisma.Client.Channel.CreateOne(
 		db.Channel.Name.Set(data.Name),
@@ -26,6 +45,7 @@ func (s *ChannelService) CreateChannel(data types.ChannelD) (*db.ChannelModel, e
 		db.Channel.Workspace.Link(
 			db.Workspace.ID.Equals(data.WorkspaceID),
 		),
+		db.Channel.Kind.Set(kind),
 	).Exec(context.Background())
 
 	if err != nil {
@@ -65,6 +85,76 @@ func (s *ChannelService) GetChannelById(channelID string) (*db.ChannelModel, err
 	return channel, nil
 }
 
+// GetChannel returns a channel the user can read.
+func (s *ChannelService) GetChannel(userID, channelID string) (*db.ChannelModel, error) {
+	if err := readableChannel(userID, channelID); err != nil {
+		return nil, err
+	}
+	return s.GetChannelById(channelID)
+}
+
+// readableChannel fails with ErrChannelNotFound unless the user can read the
+// channel: any member of its workspace for a public channel, its
+// participants otherwise.
+func readableChannel(userID, channelID string) error {
+	ctx := context.Background()
+
+	channel, err := prisma.Client.Channel.FindUnique(
+		db.Channel.ID.Equals(channelID),
+	).Exec(ctx)
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrChannelNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userID),
+		db.UserWorkspace.WorkspaceID.Equals(channel.WorkspaceID),
+	).Exec(ctx)
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrChannelNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if channel.Kind != db.ChannelKindPublic && !slices.Contains(channel.ParticipantsIDS, user.ID) {
+		return ErrChannelNotFound
+	}
+	return nil
+}
+
+// ArchiveChannel makes a channel read-only and hides it from the workspace
+// channel list.
+func (s *ChannelService) ArchiveChannel(channelID string) (*db.ChannelModel, error) {
+	channel, err := prisma.Client.Channel.FindUnique(
+		db.Channel.ID.Equals(channelID),
+	).Update(
+		db.Channel.IsArchived.Set(true),
+		db.Channel.ArchivedAt.Set(time.Now()),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+func (s *ChannelService) UnarchiveChannel(channelID string) (*db.ChannelModel, error) {
+	channel, err := prisma.Client.Channel.FindUnique(
+		db.Channel.ID.Equals(channelID),
+	).Update(
+		db.Channel.IsArchived.Set(false),
+		db.Channel.ArchivedAt.SetOptional(nil),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+// DeleteChannel removes a channel for good, along with its messages and
+// attachments. Archiving is the default way to retire a channel.
 func (s *ChannelService) DeleteChannel(channelID string) (*db.ChannelModel, error) {
 	channel, err := prisma.Client.Channel.FindUnique(
 		db.Channel.ID.Equals(channelID),
@@ -89,29 +179,486 @@ func (s *ChannelService) EditChannelName(channelID, name string) (*db.ChannelMod
 	return channel, nil
 }
 
-// ListChannelsByWorkspace lists all channels in a workspace.
-func (s *ChannelService) ListChannelsByWorkspace(workspaceID string) ([]db.ChannelModel, error) {
+// ListChannelsByWorkspace lists the named channels of a workspace the user can
+// see: every public channel and the private ones they participate in. Direct
+// conversations are left out, and so are archived channels unless asked for.
+func (s *ChannelService) ListChannelsByWorkspace(userID, workspaceID string, includeArchived bool) ([]db.ChannelModel, error) {
+	ctx := context.Background()
+
+	user, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userID),
+		db.UserWorkspace.WorkspaceID.Equals(workspaceID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []db.ChannelWhereParam{
+		db.Channel.WorkspaceID.Equals(workspaceID),
+	}
+	if !includeArchived {
+		filters = append(filters, db.Channel.Not(db.Channel.IsArchived.Equals(true)))
+	}
+
+	filters = append(filters, db.Channel.Or(
+		isPublic(),
+		db.Channel.And(
+			db.Channel.Kind.Equals(db.ChannelKindPrivate),
+			db.Channel.ParticipantsIDS.Has(user.ID),
+		),
+	))
+
+	channels, err := prisma.Client.Channel.FindMany(
+		filters...,
+	).With(db.Channel.Participants.Fetch()).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// ListJoinableChannels lists the public channels of a workspace the user is
+// not a participant of yet.
+func (s *ChannelService) ListJoinableChannels(userID, workspaceID string) ([]db.ChannelModel, error) {
+	ctx := context.Background()
+
+	user, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userID),
+		db.UserWorkspace.WorkspaceID.Equals(workspaceID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	channels, err := prisma.Client.Channel.FindMany(
 		db.Channel.WorkspaceID.Equals(workspaceID),
-	).With(db.Channel.Participants.Fetch()).Exec(context.Background())
+		isPublic(),
+		db.Channel.Not(db.Channel.IsArchived.Equals(true)),
+		db.Channel.Not(
+			db.Channel.ParticipantsIDS.Has(user.ID),
+		),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// isPublic matches public channels, including the ones stored before
+// channels had a kind.
+func isPublic() db.ChannelWhereParam {
+	return db.Channel.Not(
+		db.Channel.Kind.In([]db.ChannelKind{
+			db.ChannelKindPrivate,
+			db.ChannelKindDirect,
+			db.ChannelKindGroupDirect,
+		}),
+	)
+}
+
+// CanManageChannel reports whether the user created the channel or is an admin
+// of its workspace.
+func (s *ChannelService) CanManageChannel(userID string, channel *db.ChannelModel) (bool, error) {
+	user, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userID),
+		db.UserWorkspace.WorkspaceID.Equals(channel.WorkspaceID),
+	).Exec(context.Background())
+	if err != nil {
+		return false, err
+	}
+	if channel.CreatorID == user.ID {
+		return true, nil
+	}
+	return NewWorkspaceService().CanUserPerformAction(userID, channel.WorkspaceID, db.UserRoleAdmin)
+}
+
+// SetVisibility makes a named channel public or private.
+func (s *ChannelService) SetVisibility(channelID, kind string) (*db.ChannelModel, error) {
+	visibility := db.ChannelKind(kind)
+	if visibility != db.ChannelKindPublic && visibility != db.ChannelKindPrivate {
+		return nil, fmt.Errorf("invalid channel visibility: %s", kind)
+	}
+
+	channel, err := s.GetChannelById(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if s.IsDirect(channel) {
+		return nil, errors.New("direct channels can't change visibility")
+	}
+
+	return prisma.Client.Channel.FindUnique(
+		db.Channel.ID.Equals(channelID),
+	).Update(
+		db.Channel.Kind.Set(visibility),
+	).Exec(context.Background())
+}
+
+// JoinChannel adds the user to a public channel and announces it there.
+func (s *ChannelService) JoinChannel(userID, channelID string) (*db.ChannelModel, *db.MessageModel, error) {
+	ctx := context.Background()
+
+	channel, err := s.GetChannelById(channelID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if channel.Kind != db.ChannelKindPublic {
+		return nil, nil, errors.New("only public channels can be joined")
+	}
+	if channel.IsArchived {
+		return nil, nil, ErrChannelArchived
+	}
+
+	user, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userID),
+		db.UserWorkspace.WorkspaceID.Equals(channel.WorkspaceID),
+	).With(db.UserWorkspace.User.Fetch()).Exec(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if slices.Contains(channel.ParticipantsIDS, user.ID) {
+		return channel, nil, nil
+	}
+
+	channel, err = prisma.Client.Channel.FindUnique(
+		db.Channel.ID.Equals(channelID),
+	).With(db.Channel.Participants.Fetch()).Update(
+		db.Channel.Participants.Link(db.UserWorkspace.ID.Equals(user.ID)),
+	).Exec(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	message, err := s.postSystemMessage(channelID, userID, fmt.Sprintf("%s joined the channel", user.User().Name))
+	if err != nil {
+		return nil, nil, err
+	}
+	return channel, message, nil
+}
+
+// LeaveChannel removes the user from a named channel and announces it there.
+func (s *ChannelService) LeaveChannel(userID, channelID string) (*db.ChannelModel, *db.MessageModel, error) {
+	ctx := context.Background()
+
+	channel, err := s.GetChannelById(channelID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.IsDirect(channel) {
+		return nil, nil, errors.New("direct channels can't be left")
+	}
+	if channel.IsArchived {
+		return nil, nil, ErrChannelArchived
+	}
+
+	user, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userID),
+		db.UserWorkspace.WorkspaceID.Equals(channel.WorkspaceID),
+	).With(db.UserWorkspace.User.Fetch()).Exec(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !slices.Contains(channel.ParticipantsIDS, user.ID) {
+		return channel, nil, nil
+	}
+
+	channel, err = prisma.Client.Channel.FindUnique(
+		db.Channel.ID.Equals(channelID),
+	).With(db.Channel.Participants.Fetch()).Update(
+		db.Channel.Participants.Unlink(db.UserWorkspace.ID.Equals(user.ID)),
+	).Exec(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	message, err := s.postSystemMessage(channelID, userID, fmt.Sprintf("%s left the channel", user.User().Name))
+	if err != nil {
+		return nil, nil, err
+	}
+	return channel, message, nil
+}
+
+// RemoveParticipants removes users from a named channel on behalf of actorID
+// and announces it there.
+func (s *ChannelService) RemoveParticipants(actorID, channelID string, userIDs []string) ([]db.UserWorkspaceModel, *db.MessageModel, error) {
+	ctx := context.Background()
+
+	channel, err := s.GetChannelById(channelID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.IsDirect(channel) {
+		return nil, nil, errors.New("can't remove participants from direct channels")
+	}
+	if channel.IsArchived {
+		return nil, nil, ErrChannelArchived
+	}
+
+	users, err := prisma.Client.UserWorkspace.FindMany(
+		db.UserWorkspace.UserID.In(userIDs),
+		db.UserWorkspace.WorkspaceID.Equals(channel.WorkspaceID),
+	).With(db.UserWorkspace.User.Fetch()).Exec(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only users who were actually in the channel are removed and announced.
+	removed := users[:0]
+	var names []string
+	for _, user := range users {
+		if !slices.Contains(channel.ParticipantsIDS, user.ID) {
+			continue
+		}
+		_, err = prisma.Client.Channel.FindUnique(
+			db.Channel.ID.Equals(channelID),
+		).Update(
+			db.Channel.Participants.Unlink(db.UserWorkspace.ID.Equals(user.ID)),
+		).Exec(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		removed = append(removed, user)
+		names = append(names, user.User().Name)
+	}
+	if len(names) == 0 {
+		return removed, nil, nil
+	}
+
+	actor, err := prisma.Client.User.FindUnique(
+		db.User.ID.Equals(actorID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	message, err := s.postSystemMessage(channelID, actorID, fmt.Sprintf("%s removed %s from the channel", actor.Name, strings.Join(names, ", ")))
+	if err != nil {
+		return nil, nil, err
+	}
+	return removed, message, nil
+}
+
+// postSystemMessage saves a membership announcement in the channel.
+func (s *ChannelService) postSystemMessage(channelID, senderID, content string) (*db.MessageModel, error) {
+	return NewMessageService().SendMessage(types.MessageD{
+		ChannelID: channelID,
+		SenderID:  senderID,
+		Content:   content,
+		IsSystem:  true,
+	})
+}
+
+// IsDirect reports whether the channel is a direct or group direct conversation.
+func (s *ChannelService) IsDirect(channel *db.ChannelModel) bool {
+	return channel.Kind == db.ChannelKindDirect || channel.Kind == db.ChannelKindGroupDirect
+}
+
+// GetOrCreateDirectChannel returns the direct conversation between the user and
+// the given users of a workspace, creating it the first time. Two participants
+// make a DIRECT channel and more make a GROUP_DIRECT one.
+func (s *ChannelService) GetOrCreateDirectChannel(userID, workspaceID string, userIDs []string) (*db.ChannelModel, error) {
+	ctx := context.Background()
+
+	unique := map[string]struct{}{userID: {}}
+	for _, id := range userIDs {
+		unique[id] = struct{}{}
+	}
+	ids := make([]string, 0, len(unique))
+	for id := range unique {
+		ids = append(ids, id)
+	}
+
+	participants, err := prisma.Client.UserWorkspace.FindMany(
+		db.UserWorkspace.UserID.In(ids),
+		db.UserWorkspace.WorkspaceID.Equals(workspaceID),
+	).With(db.UserWorkspace.User.Fetch()).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(participants) != len(ids) {
+		return nil, errors.New("all participants must be part of the workspace")
+	}
+
+	var (
+		creatorID      string
+		participantIDs []string
+		names          []string
+	)
+	for _, participant := range participants {
+		if participant.UserID == userID {
+			creatorID = participant.ID
+		}
+		participantIDs = append(participantIDs, participant.ID)
+		names = append(names, participant.User().Name)
+	}
+	sort.Strings(participantIDs)
+	sort.Strings(names)
+	key := workspaceID + ":" + strings.Join(participantIDs, ",")
+
+	kind := db.ChannelKindDirect
+	if len(participantIDs) > 2 {
+		kind = db.ChannelKindGroupDirect
+	}
+
+	existing, err := s.findDirectChannel(key, workspaceID, kind, participantIDs)
+	if err == nil || !errors.Is(err, db.ErrNotFound) {
+		return existing, err
+	}
+
+	channel, err := prisma.Client.Channel.CreateOne(
+		db.Channel.Name.Set(strings.Join(names, ", ")),
+		db.Channel.CreatorID.Set(creatorID),
+		db.Channel.Workspace.Link(
+			db.Workspace.ID.Equals(workspaceID),
+		),
+		db.Channel.Kind.Set(kind),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the key is claimed before anyone is added, a concurrent request that
+	// claimed it first wins and this channel is dropped
+	_, err = prisma.Client.DirectChannelKey.CreateOne(
+		db.DirectChannelKey.Key.Set(key),
+		db.DirectChannelKey.ChannelID.Set(channel.ID),
+	).Exec(ctx)
+	if err != nil {
+		if _, err := prisma.Client.Channel.FindUnique(
+			db.Channel.ID.Equals(channel.ID),
+		).Delete().Exec(ctx); err != nil {
+			logger.LogError().Msgf("Failed to drop duplicate direct channel %s: %v", channel.ID, err)
+		}
+		if _, exists := db.IsErrUniqueConstraint(err); exists {
+			return s.findDirectChannel(key, workspaceID, kind, participantIDs)
+		}
+		return nil, err
+	}
+
+	for _, id := range participantIDs {
+		channel, err = prisma.Client.Channel.FindUnique(
+			db.Channel.ID.Equals(channel.ID),
+		).With(db.Channel.Participants.Fetch()).Update(
+			db.Channel.Participants.Link(db.UserWorkspace.ID.Equals(id)),
+		).Exec(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return channel, nil
+}
+
+// findDirectChannel looks a direct channel up by its key. Channels created
+// before keys existed are matched on their participants and given one.
+func (s *ChannelService) findDirectChannel(key, workspaceID string, kind db.ChannelKind, participantIDs []string) (*db.ChannelModel, error) {
+	ctx := context.Background()
+
+	directKey, err := prisma.Client.DirectChannelKey.FindUnique(
+		db.DirectChannelKey.Key.Equals(key),
+	).Exec(ctx)
+	if err == nil {
+		channel, err := prisma.Client.Channel.FindUnique(
+			db.Channel.ID.Equals(directKey.ChannelID),
+		).With(db.Channel.Participants.Fetch()).Exec(ctx)
+		if !errors.Is(err, db.ErrNotFound) {
+			return channel, err
+		}
+		// the channel was deleted, its key is free again
+		_, err = prisma.Client.DirectChannelKey.FindUnique(
+			db.DirectChannelKey.ID.Equals(directKey.ID),
+		).Delete().Exec(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return nil, db.ErrNotFound
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+
+	existing, err := prisma.Client.Channel.FindMany(
+		db.Channel.WorkspaceID.Equals(workspaceID),
+		db.Channel.Kind.Equals(kind),
+		db.Channel.ParticipantsIDS.HasEvery(participantIDs),
+	).With(db.Channel.Participants.Fetch()).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range existing {
+		if len(existing[i].ParticipantsIDS) != len(participantIDs) {
+			continue
+		}
+		_, err := prisma.Client.DirectChannelKey.CreateOne(
+			db.DirectChannelKey.Key.Set(key),
+			db.DirectChannelKey.ChannelID.Set(existing[i].ID),
+		).Exec(ctx)
+		if _, exists := db.IsErrUniqueConstraint(err); exists {
+			return s.findDirectChannel(key, workspaceID, kind, participantIDs)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &existing[i], nil
+	}
+	return nil, db.ErrNotFound
+}
+
+// ListDirectChannels lists the direct conversations a user takes part in.
+func (s *ChannelService) ListDirectChannels(userID, workspaceID string) ([]db.ChannelModel, error) {
+	ctx := context.Background()
+
+	user, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userID),
+		db.UserWorkspace.WorkspaceID.Equals(workspaceID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := prisma.Client.Channel.FindMany(
+		db.Channel.WorkspaceID.Equals(workspaceID),
+		db.Channel.Kind.In([]db.ChannelKind{db.ChannelKindDirect, db.ChannelKindGroupDirect}),
+		db.Channel.ParticipantsIDS.Has(user.ID),
+	).With(db.Channel.Participants.Fetch()).Exec(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return channels, nil
 }
 
-func (s *ChannelService) AddParticipants(workspaceID string, channelID string, userIDs []string) ([]*db.UserWorkspaceModel, error) {
+// AddParticipants adds members of the channel's workspace to a named channel
+// on behalf of actorID and announces it there.
+func (s *ChannelService) AddParticipants(actorID, channelID string, userIDs []string) ([]*db.UserWorkspaceModel, *db.MessageModel, error) {
 	ctx := context.Background()
 	var addedUsers []*db.UserWorkspaceModel
+	var names []string
+
+	channel, err := s.GetChannelById(channelID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.IsDirect(channel) {
+		return nil, nil, errors.New("can't add participants to direct channels")
+	}
+	if channel.IsArchived {
+		return nil, nil, ErrChannelArchived
+	}
 
 	for _, userID := range userIDs {
 		// Ensure userID is already a valid ObjectID as string
 		user, err := prisma.Client.UserWorkspace.FindFirst(
 			db.UserWorkspace.UserID.Equals(userID), // Expect userID to be a valid string
-			db.UserWorkspace.WorkspaceID.Equals(workspaceID),
-		).Exec(ctx)
+			db.UserWorkspace.WorkspaceID.Equals(channel.WorkspaceID),
+		).With(db.UserWorkspace.User.Fetch()).Exec(ctx)
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, nil, fmt.Errorf("user %s is not part of the channel's workspace", userID)
+		}
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if slices.Contains(channel.ParticipantsIDS, user.ID) {
+			continue
 		}
 
 		// Add the user to the channel's participants
@@ -121,7 +668,7 @@ func (s *ChannelService) AddParticipants(workspaceID string, channelID string, u
 			db.Channel.Participants.Link(db.UserWorkspace.ID.Equals(user.ID)),
 		).Exec(ctx)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		fmt.Println("added", user.ID)
@@ -132,7 +679,22 @@ func (s *ChannelService) AddParticipants(workspaceID string, channelID string, u
 
 		logger.LogDebug().Msg("Added user to channel")
 		addedUsers = append(addedUsers, user)
+		names = append(names, user.User().Name)
+	}
+	if len(names) == 0 {
+		return addedUsers, nil, nil
 	}
 
-	return addedUsers, nil
+	actor, err := prisma.Client.User.FindUnique(
+		db.User.ID.Equals(actorID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	message, err := s.postSystemMessage(channelID, actorID, fmt.Sprintf("%s added %s to the channel", actor.Name, strings.Join(names, ", ")))
+	if err != nil {
+		return nil, nil, err
+	}
+	return addedUsers, message, nil
 }
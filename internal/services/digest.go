@@ -0,0 +1,429 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/CollabTED/CollabTed-Backend/config"
+	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
+	"github.com/CollabTED/CollabTed-Backend/pkg/mail"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+const (
+	digestInterval   = 15 * time.Minute
+	digestMaxItems   = 20
+	digestMaxContent = 200
+)
+
+// DigestService emails users a summary of the mentions, direct messages,
+// tasks and events they missed, daily or weekly at a local hour of their
+// choosing.
+type DigestService struct {
+	prefs *NotificationPreferenceService
+}
+
+func NewDigestService() *DigestService {
+	return &DigestService{
+		prefs: NewNotificationPreferenceService(),
+	}
+}
+
+// GetSettings returns the user's digest settings, creating the defaults
+// the first time.
+func (s *DigestService) GetSettings(userID string) (*db.DigestSettingsModel, error) {
+	ctx := context.Background()
+
+	settings, err := prisma.Client.DigestSettings.FindUnique(
+		db.DigestSettings.UserID.Equals(userID),
+	).Exec(ctx)
+	if err == nil {
+		return settings, nil
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+
+	next := nextDigestAt(db.DigestFrequencyDaily, 8, 1, "UTC", time.Now())
+	settings, err = prisma.Client.DigestSettings.CreateOne(
+		db.DigestSettings.UserID.Set(userID),
+		db.DigestSettings.NextDigestAt.Set(next),
+	).Exec(ctx)
+	if _, ok := db.IsErrUniqueConstraint(err); ok {
+		// created concurrently, by another request or replica
+		return prisma.Client.DigestSettings.FindUnique(
+			db.DigestSettings.UserID.Equals(userID),
+		).Exec(ctx)
+	}
+	return settings, err
+}
+
+func (s *DigestService) UpdateSettings(userID string, data types.DigestSettingsD) (*db.DigestSettingsModel, error) {
+	frequency := db.DigestFrequency(data.Frequency)
+	switch frequency {
+	case db.DigestFrequencyDaily, db.DigestFrequencyWeekly, db.DigestFrequencyOff:
+	default:
+		return nil, fmt.Errorf("invalid frequency: %s", data.Frequency)
+	}
+	if data.Hour < 0 || data.Hour > 23 {
+		return nil, errors.New("hour must be between 0 and 23")
+	}
+	if data.Weekday < 0 || data.Weekday > 6 {
+		return nil, errors.New("weekday must be between 0 (Sunday) and 6")
+	}
+	if _, err := time.LoadLocation(data.Timezone); err != nil || data.Timezone == "" {
+		return nil, fmt.Errorf("invalid timezone: %s", data.Timezone)
+	}
+
+	if _, err := s.GetSettings(userID); err != nil {
+		return nil, err
+	}
+
+	next := nextDigestAt(frequency, data.Hour, data.Weekday, data.Timezone, time.Now())
+	return prisma.Client.DigestSettings.FindUnique(
+		db.DigestSettings.UserID.Equals(userID),
+	).Update(
+		db.DigestSettings.Frequency.Set(frequency),
+		db.DigestSettings.Hour.Set(data.Hour),
+		db.DigestSettings.Weekday.Set(data.Weekday),
+		db.DigestSettings.Timezone.Set(data.Timezone),
+		db.DigestSettings.NextDigestAt.Set(next),
+	).Exec(context.Background())
+}
+
+// Run sends the digests as they come due. Every user gets the default
+// settings on start so that nobody has to opt in.
+func (s *DigestService) Run() {
+	if err := s.backfillSettings(); err != nil {
+		logger.LogError().Msgf("Failed to create digest settings: %v", err)
+	}
+
+	ticker := time.NewTicker(digestInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if err := s.sendDue(now); err != nil {
+			logger.LogError().Msgf("Failed to send digests: %v", err)
+		}
+	}
+}
+
+func (s *DigestService) backfillSettings() error {
+	ctx := context.Background()
+
+	// bots have no inbox to summarize, users stored before isBot existed
+	// aren't bots
+	users, err := prisma.Client.User.FindMany(
+		db.User.Not(db.User.IsBot.Equals(true)),
+	).Exec(ctx)
+	if err != nil {
+		return err
+	}
+	existing, err := prisma.Client.DigestSettings.FindMany().Exec(ctx)
+	if err != nil {
+		return err
+	}
+	has := make(map[string]struct{}, len(existing))
+	for _, settings := range existing {
+		has[settings.UserID] = struct{}{}
+	}
+
+	for _, user := range users {
+		if _, ok := has[user.ID]; ok {
+			continue
+		}
+		if _, err := s.GetSettings(user.ID); err != nil {
+			logger.LogError().Msgf("Failed to create the digest settings of %s: %v", user.ID, err)
+		}
+	}
+	return nil
+}
+
+// sendDue claims each due digest by moving it to its next date, so that only
+// one replica sends it. Digests due during the user's quiet hours wait for
+// the first run after them.
+func (s *DigestService) sendDue(now time.Time) error {
+	ctx := context.Background()
+
+	due, err := prisma.Client.DigestSettings.FindMany(
+		db.DigestSettings.NextDigestAt.Lte(now),
+		db.DigestSettings.Frequency.In([]db.DigestFrequency{
+			db.DigestFrequencyDaily,
+			db.DigestFrequencyWeekly,
+		}),
+	).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, settings := range due {
+		if s.prefs.InQuietHours(settings.UserID, now) {
+			continue
+		}
+
+		next := nextDigestAt(settings.Frequency, settings.Hour, settings.Weekday, settings.Timezone, now)
+		res, err := prisma.Client.DigestSettings.FindMany(
+			db.DigestSettings.ID.Equals(settings.ID),
+			db.DigestSettings.NextDigestAt.Equals(settings.NextDigestAt),
+		).Update(
+			db.DigestSettings.NextDigestAt.Set(next),
+		).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if res.Count == 0 {
+			continue
+		}
+
+		since, ok := settings.LastSentAt()
+		if !ok {
+			since = now.Add(-digestPeriod(settings.Frequency))
+		}
+		if _, err := s.SendDigest(settings.UserID, since, now, next); err != nil {
+			// the next digest covers this period again
+			logger.LogError().Msgf("Failed to send digest to %s: %v", settings.UserID, err)
+			continue
+		}
+
+		_, err = prisma.Client.DigestSettings.FindUnique(
+			db.DigestSettings.ID.Equals(settings.ID),
+		).Update(
+			db.DigestSettings.LastSentAt.Set(now),
+		).Exec(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendDigest queues an email of the activity between since and now along
+// with the events coming up until the next digest. Nothing is sent when there
+// is nothing to report, which it reports as false.
+func (s *DigestService) SendDigest(userID string, since, now, until time.Time) (bool, error) {
+	user, err := prisma.Client.User.FindUnique(
+		db.User.ID.Equals(userID),
+	).Exec(context.Background())
+	if err != nil {
+		return false, err
+	}
+
+	digest, err := s.BuildDigest(userID, since, now, until)
+	if err != nil {
+		return false, err
+	}
+	if digest.IsEmpty() {
+		return false, nil
+	}
+
+	if err := mail.Send(mail.TemplateDigest, []string{user.Email}, digest); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// BuildDigest collects, across the user's workspaces, the unread mentions and
+// direct messages sent since then, the tasks assigned to the user that were
+// created since then and the events they attend between now and until.
+// Channels the user muted, or only wants mentions from, are left out.
+func (s *DigestService) BuildDigest(userID string, since, now, until time.Time) (*types.Digest, error) {
+	ctx := context.Background()
+
+	user, err := prisma.Client.User.FindUnique(
+		db.User.ID.Equals(userID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	digest := &types.Digest{
+		UserName: user.Name,
+		Since:    since,
+		AppURL:   config.HOST_URL,
+	}
+
+	memberships, err := prisma.Client.UserWorkspace.FindMany(
+		db.UserWorkspace.UserID.Equals(userID),
+	).With(
+		db.UserWorkspace.Workspace.Fetch(),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range memberships {
+		workspace := member.Workspace().WorkspaceName
+
+		if err := s.collectMessages(digest, &member, workspace, since); err != nil {
+			return nil, err
+		}
+
+		tasks, err := prisma.Client.Task.FindMany(
+			db.Task.AssineesIds.Has(member.ID),
+			db.Task.CreatedAt.Gt(since),
+		).With(
+			db.Task.Project.Fetch(),
+		).OrderBy(
+			db.Task.DueDate.Order(db.SortOrderAsc),
+		).Exec(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			digest.Tasks = append(digest.Tasks, types.DigestTask{
+				Workspace: workspace,
+				Project:   task.Project().Title,
+				Title:     task.Title,
+				DueDate:   task.DueDate,
+			})
+		}
+
+		events, err := prisma.Client.Event.FindMany(
+			db.Event.AssineesIds.Has(member.ID),
+			db.Event.StartTime.Gte(now),
+			db.Event.StartTime.Lt(until),
+		).OrderBy(
+			db.Event.StartTime.Order(db.SortOrderAsc),
+		).Exec(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			digest.Events = append(digest.Events, types.DigestEvent{
+				Workspace: workspace,
+				Name:      event.Name,
+				StartTime: event.StartTime,
+				MeetLink:  event.MeetLink,
+			})
+		}
+	}
+
+	digest.Mentions = truncate(digest.Mentions)
+	digest.DirectMessages = truncate(digest.DirectMessages)
+	digest.Tasks = truncate(digest.Tasks)
+	digest.Events = truncate(digest.Events)
+	return digest, nil
+}
+
+// collectMessages adds the messages of the member's channels that are newer
+// than both since and the member's read pointer, keeping mentions and
+// direct messages.
+func (s *DigestService) collectMessages(digest *types.Digest, member *db.UserWorkspaceModel, workspace string, since time.Time) error {
+	ctx := context.Background()
+
+	channels, err := prisma.Client.Channel.FindMany(
+		db.Channel.ParticipantsIDS.Has(member.ID),
+	).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	reads, err := prisma.Client.ChannelRead.FindMany(
+		db.ChannelRead.UserWorkspaceID.Equals(member.ID),
+	).Exec(ctx)
+	if err != nil {
+		return err
+	}
+	readAt := make(map[string]time.Time, len(reads))
+	for _, read := range reads {
+		readAt[read.ChannelID] = read.LastReadAt
+	}
+
+	for _, channel := range channels {
+		// direct messages count as mentions for the notification levels
+		if !allows(member.UserID, member.WorkspaceID, channel.ID, true) {
+			continue
+		}
+
+		after := since
+		if read, ok := readAt[channel.ID]; ok && read.After(after) {
+			after = read
+		}
+		direct := (&ChannelService{}).IsDirect(&channel)
+
+		messages, err := prisma.Client.Message.FindMany(
+			db.Message.ChannelID.Equals(channel.ID),
+			db.Message.CreatedAt.Gt(after),
+			db.Message.SenderID.Not(member.UserID),
+			db.Message.IsSystem.Equals(false),
+			db.Message.IsDeleted.Equals(false),
+		).With(
+			db.Message.Sender.Fetch(),
+		).OrderBy(
+			db.Message.CreatedAt.Order(db.SortOrderAsc),
+		).Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			item := types.DigestMessage{
+				Workspace: workspace,
+				Channel:   channel.Name,
+				Sender:    message.Sender().Name,
+				Content:   shorten(message.Content),
+				SentAt:    message.CreatedAt,
+			}
+			switch {
+			case mentions(message.MentionIds, member.ID):
+				digest.Mentions = append(digest.Mentions, item)
+			case direct:
+				digest.DirectMessages = append(digest.DirectMessages, item)
+			}
+		}
+	}
+	return nil
+}
+
+func nextDigestAt(frequency db.DigestFrequency, hour, weekday int, timezone string, after time.Time) time.Time {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := after.In(loc)
+
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, 0, 0, 0, loc)
+	step := 1
+	if frequency == db.DigestFrequencyWeekly {
+		next = next.AddDate(0, 0, (weekday-int(next.Weekday())+7)%7)
+		step = 7
+	}
+	for !next.After(after) {
+		next = next.AddDate(0, 0, step)
+	}
+	return next
+}
+
+func digestPeriod(frequency db.DigestFrequency) time.Duration {
+	if frequency == db.DigestFrequencyWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func mentions(mentionIDs []string, userWorkspaceID string) bool {
+	for _, id := range mentionIDs {
+		if id == userWorkspaceID {
+			return true
+		}
+	}
+	return false
+}
+
+func shorten(content string) string {
+	if utf8.RuneCountInString(content) <= digestMaxContent {
+		return content
+	}
+	return string([]rune(content)[:digestMaxContent]) + "…"
+}
+
+func truncate[T any](items []T) []T {
+	if len(items) > digestMaxItems {
+		return items[:digestMaxItems]
+	}
+	return items
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+func TestNextDigestAt(t *testing.T) {
+	// a Tuesday
+	after := time.Date(2024, 3, 5, 10, 30, 0, 0, time.UTC)
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("no timezone database: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		frequency db.DigestFrequency
+		hour      int
+		weekday   int
+		timezone  string
+		want      time.Time
+	}{
+		{"daily, later today", db.DigestFrequencyDaily, 18, 0, "UTC", time.Date(2024, 3, 5, 18, 0, 0, 0, time.UTC)},
+		{"daily, tomorrow", db.DigestFrequencyDaily, 8, 0, "UTC", time.Date(2024, 3, 6, 8, 0, 0, 0, time.UTC)},
+		{"daily, same hour is past", db.DigestFrequencyDaily, 10, 0, "UTC", time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)},
+		{"weekly, later this week", db.DigestFrequencyWeekly, 8, 5, "UTC", time.Date(2024, 3, 8, 8, 0, 0, 0, time.UTC)},
+		{"weekly, next week", db.DigestFrequencyWeekly, 8, 1, "UTC", time.Date(2024, 3, 11, 8, 0, 0, 0, time.UTC)},
+		{"weekly, today already past", db.DigestFrequencyWeekly, 8, 2, "UTC", time.Date(2024, 3, 12, 8, 0, 0, 0, time.UTC)},
+		{"timezone", db.DigestFrequencyDaily, 12, 0, "Europe/Paris", time.Date(2024, 3, 5, 12, 0, 0, 0, paris)},
+		{"invalid timezone falls back to UTC", db.DigestFrequencyDaily, 18, 0, "Nowhere/City", time.Date(2024, 3, 5, 18, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nextDigestAt(tt.frequency, tt.hour, tt.weekday, tt.timezone, after)
+			if !got.Equal(tt.want) {
+				t.Errorf("nextDigestAt() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
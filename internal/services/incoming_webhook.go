@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+const (
+	maxIncomingTextLength  = 4000
+	maxIncomingTitleLength = 200
+)
+
+var ErrInvalidWebhookToken = errors.New("invalid webhook token")
+
+// IncomingWebhookService lets integrations post into a channel through a
+// secret URL, as a bot user created for each webhook.
+type IncomingWebhookService struct{}
+
+func NewIncomingWebhookService() *IncomingWebhookService {
+	return &IncomingWebhookService{}
+}
+
+// CreatedIncomingWebhook carries the token of a new webhook, which can't be
+// recovered later.
+type CreatedIncomingWebhook struct {
+	*db.IncomingWebhookModel
+	Token string `json:"token"`
+}
+
+func (s *IncomingWebhookService) CreateIncomingWebhook(userID string, channel *db.ChannelModel, data types.IncomingWebhookD) (*CreatedIncomingWebhook, error) {
+	ctx := context.Background()
+
+	name := strings.TrimSpace(data.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if channel.IsArchived {
+		return nil, ErrChannelArchived
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	botEmail, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	// not a bcrypt hash, so no password ever matches it
+	password, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	bot, err := prisma.Client.User.CreateOne(
+		db.User.Email.Set("webhook-"+botEmail+"@bots.collabted"),
+		db.User.Name.Set(name),
+		db.User.Password.Set(password),
+		db.User.ProfilePicture.Set(""),
+		db.User.Active.Set(false),
+		db.User.IsOAuth.Set(false),
+		db.User.IsBot.Set(true),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bot user: %v", err)
+	}
+
+	webhook, err := prisma.Client.IncomingWebhook.CreateOne(
+		db.IncomingWebhook.ChannelID.Set(channel.ID),
+		db.IncomingWebhook.WorkspaceID.Set(channel.WorkspaceID),
+		db.IncomingWebhook.CreatorID.Set(userID),
+		db.IncomingWebhook.BotID.Set(bot.ID),
+		db.IncomingWebhook.Name.Set(name),
+		db.IncomingWebhook.TokenHash.Set(hashToken(token)),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create incoming webhook: %v", err)
+	}
+
+	webhook.TokenHash = ""
+	return &CreatedIncomingWebhook{IncomingWebhookModel: webhook, Token: token}, nil
+}
+
+func (s *IncomingWebhookService) ListIncomingWebhooks(channelID string) ([]db.IncomingWebhookModel, error) {
+	webhooks, err := prisma.Client.IncomingWebhook.FindMany(
+		db.IncomingWebhook.ChannelID.Equals(channelID),
+	).OrderBy(
+		db.IncomingWebhook.CreatedAt.Order(db.SortOrderDesc),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].TokenHash = ""
+	}
+	return webhooks, nil
+}
+
+// DeleteIncomingWebhook revokes the webhook's URL. Its bot user is kept as
+// the sender of the messages it already posted.
+func (s *IncomingWebhookService) DeleteIncomingWebhook(channelID, webhookID string) error {
+	ctx := context.Background()
+
+	webhook, err := prisma.Client.IncomingWebhook.FindFirst(
+		db.IncomingWebhook.ID.Equals(webhookID),
+		db.IncomingWebhook.ChannelID.Equals(channelID),
+	).Exec(ctx)
+	if err != nil {
+		return errors.New("incoming webhook not found")
+	}
+
+	_, err = prisma.Client.IncomingWebhook.FindUnique(
+		db.IncomingWebhook.ID.Equals(webhook.ID),
+	).Delete().Exec(ctx)
+	return err
+}
+
+// Authenticate returns the webhook matching the id and token.
+func (s *IncomingWebhookService) Authenticate(webhookID, token string) (*db.IncomingWebhookModel, error) {
+	webhook, err := prisma.Client.IncomingWebhook.FindUnique(
+		db.IncomingWebhook.TokenHash.Equals(hashToken(token)),
+	).Exec(context.Background())
+	if err != nil || webhook.ID != webhookID {
+		return nil, ErrInvalidWebhookToken
+	}
+	return webhook, nil
+}
+
+// ToMessage validates a payload and turns it into the message posted to the
+// channel. The title goes on top of the text, or titles the attachment link
+// when there is one. Links must be absolute http or https URLs, since clients
+// render them as is.
+func (s *IncomingWebhookService) ToMessage(payload types.IncomingWebhookPayload) (types.MessageD, error) {
+	text := strings.TrimSpace(payload.Text)
+	title := strings.TrimSpace(payload.Title)
+	link := strings.TrimSpace(payload.AttachmentLink)
+	if text == "" && link == "" {
+		return types.MessageD{}, errors.New("text or attachmentLink is required")
+	}
+	if utf8.RuneCountInString(title) > maxIncomingTitleLength {
+		return types.MessageD{}, fmt.Errorf("title can't be longer than %d characters", maxIncomingTitleLength)
+	}
+
+	msg := types.MessageD{Content: text}
+	if link != "" {
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return types.MessageD{}, errors.New("attachmentLink must be an absolute http or https URL")
+		}
+		msg.AttachmentLink = link
+		msg.AttachmentTitle = title
+	} else if title != "" {
+		msg.Content = title + "\n\n" + text
+	}
+	if utf8.RuneCountInString(msg.Content) > maxIncomingTextLength {
+		return types.MessageD{}, fmt.Errorf("text can't be longer than %d characters", maxIncomingTextLength)
+	}
+	return msg, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
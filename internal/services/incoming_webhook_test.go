@@ -0,0 +1,71 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+)
+
+func TestToMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload types.IncomingWebhookPayload
+		want    types.MessageD
+		wantErr bool
+	}{
+		{
+			name:    "text",
+			payload: types.IncomingWebhookPayload{Text: " build passed "},
+			want:    types.MessageD{Content: "build passed"},
+		},
+		{
+			name:    "title on top of the text",
+			payload: types.IncomingWebhookPayload{Title: "CI", Text: "build passed"},
+			want:    types.MessageD{Content: "CI\n\nbuild passed"},
+		},
+		{
+			name:    "title of the attachment",
+			payload: types.IncomingWebhookPayload{Title: "report", Text: "see", AttachmentLink: "https://example.com/r.pdf"},
+			want:    types.MessageD{Content: "see", AttachmentTitle: "report", AttachmentLink: "https://example.com/r.pdf"},
+		},
+		{
+			name:    "empty",
+			payload: types.IncomingWebhookPayload{Title: "CI", Text: "  "},
+			wantErr: true,
+		},
+		{
+			name:    "too long",
+			payload: types.IncomingWebhookPayload{Text: strings.Repeat("a", maxIncomingTextLength+1)},
+			wantErr: true,
+		},
+		{
+			name:    "title too long",
+			payload: types.IncomingWebhookPayload{Title: strings.Repeat("a", maxIncomingTitleLength+1), Text: "see"},
+			wantErr: true,
+		},
+		{
+			name:    "relative link",
+			payload: types.IncomingWebhookPayload{AttachmentLink: "/r.pdf"},
+			wantErr: true,
+		},
+		{
+			name:    "script link",
+			payload: types.IncomingWebhookPayload{AttachmentLink: "javascript:alert(1)"},
+			wantErr: true,
+		},
+	}
+
+	srv := NewIncomingWebhookService()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := srv.ToMessage(tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ToMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ToMessage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
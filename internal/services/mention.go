@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/redis"
+	"github.com/CollabTED/CollabTed-Backend/prisma"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+const (
+	mentionChannel = "channel"
+	mentionHere    = "here"
+)
+
+// resolveMentions returns the user workspace IDs of the channel participants
+// mentioned in content. @channel mentions every participant and @here the
+// ones currently connected. The sender is never mentioned.
+func resolveMentions(channelID, senderID, content string) ([]string, error) {
+	if !strings.Contains(content, "@") {
+		return nil, nil
+	}
+
+	channel, err := prisma.Client.Channel.FindUnique(
+		db.Channel.ID.Equals(channelID),
+	).With(
+		db.Channel.Participants.Fetch().With(
+			db.UserWorkspace.User.Fetch(),
+		),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]db.UserWorkspaceModel)
+	for _, participant := range channel.Participants() {
+		if participant.UserID == senderID {
+			continue
+		}
+		byName[participant.User().Name] = participant
+	}
+
+	names, everyone, here := parseMentions(content, byName)
+
+	mentioned := make(map[string]struct{})
+	for _, name := range names {
+		mentioned[byName[name].ID] = struct{}{}
+	}
+	rdb := redis.GetClient()
+	for _, participant := range byName {
+		if everyone {
+			mentioned[participant.ID] = struct{}{}
+			continue
+		}
+		if here {
+			connected, err := rdb.HExists(context.Background(), "connected", "user:"+participant.UserID).Result()
+			if err == nil && connected {
+				mentioned[participant.ID] = struct{}{}
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(mentioned))
+	for id := range mentioned {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseMentions finds the names from candidates mentioned in content as
+// @name, preferring the longest name when several match, and whether the
+// message mentions @channel or @here.
+func parseMentions(content string, candidates map[string]db.UserWorkspaceModel) (names []string, everyone, here bool) {
+	sorted := make([]string, 0, len(candidates))
+	for name := range candidates {
+		sorted = append(sorted, name)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	seen := make(map[string]bool)
+	for i := strings.Index(content, "@"); i != -1; {
+		rest := content[i+1:]
+		switch {
+		case hasMention(rest, mentionChannel):
+			everyone = true
+		case hasMention(rest, mentionHere):
+			here = true
+		default:
+			for _, name := range sorted {
+				if hasMention(rest, name) {
+					if !seen[name] {
+						seen[name] = true
+						names = append(names, name)
+					}
+					break
+				}
+			}
+		}
+
+		next := strings.Index(rest, "@")
+		if next == -1 {
+			break
+		}
+		i += next + 1
+	}
+	return names, everyone, here
+}
+
+// hasMention reports whether s starts with name followed by a word boundary.
+func hasMention(s, name string) bool {
+	if name == "" || !strings.HasPrefix(s, name) {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(s[len(name):])
+	return r == utf8.RuneError || !(unicode.IsLetter(r) || unicode.IsDigit(r))
+}
@@ -2,8 +2,13 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 
+	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
+	"github.com/CollabTED/CollabTed-Backend/pkg/search"
 	"github.com/CollabTED/CollabTed-Backend/pkg/types"
 	"github.com/CollabTED/CollabTed-Backend/prisma"
 	"github.com/CollabTED/CollabTed-Backend/prisma/db"
@@ -11,12 +16,83 @@ import (
 
 type MessageService struct{}
 
+// MessageWithReactions is a channel message along with its aggregated reactions.
+type MessageWithReactions struct {
+	db.MessageModel
+	ReactionCounts []types.ReactionCount `json:"reactionCounts"`
+}
+
 func NewMessageService() *MessageService {
 	return &MessageService{}
 }
 
+// MessagesPage is a window of channel messages in chronological order.
+// PrevCursor and NextCursor are empty when there is nothing older or newer.
+type MessagesPage struct {
+	Messages   []MessageWithReactions `json:"messages"`
+	PrevCursor string                 `json:"prevCursor"`
+	NextCursor string                 `json:"nextCursor"`
+}
+
+const (
+	defaultMessagesLimit = 50
+	maxMessagesLimit     = 100
+)
+
+// ErrAnchorNotFound is returned when the anchor of a page of messages isn't
+// a message of the channel.
+var ErrAnchorNotFound = errors.New("anchor message not found in this channel")
+
 func (s *MessageService) SendMessage(data types.MessageD) (*db.MessageModel, error) {
-	message, err := prisma.Client.Message.CreateOne(
+	ctx := context.Background()
+
+	if err := checkWritable(data.ChannelID); err != nil {
+		return nil, err
+	}
+
+	var optional []db.MessageSetParam
+	if data.ParentID != "" {
+		parent, err := prisma.Client.Message.FindUnique(
+			db.Message.ID.Equals(data.ParentID),
+		).Exec(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("parent message not found: %v", err)
+		}
+		if parent.ChannelID != data.ChannelID {
+			return nil, errors.New("parent message belongs to another channel")
+		}
+		// threads are one level deep, replying to a reply attaches to its root
+		if rootID, ok := parent.ParentID(); ok {
+			parent, err = prisma.Client.Message.FindUnique(
+				db.Message.ID.Equals(rootID),
+			).Exec(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("parent message not found: %v", err)
+			}
+			data.ParentID = parent.ID
+		}
+		if parent.IsDeleted {
+			return nil, errors.New("can't reply to a deleted message")
+		}
+		optional = append(optional,
+			db.Message.Parent.Link(db.Message.ID.Equals(data.ParentID)),
+			db.Message.IsReply.Set(true),
+		)
+	}
+
+	if data.IsSystem {
+		optional = append(optional, db.Message.IsSystem.Set(true))
+	}
+
+	mentionIDs, err := resolveMentions(data.ChannelID, data.SenderID, data.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve mentions: %v", err)
+	}
+	if len(mentionIDs) > 0 {
+		optional = append(optional, db.Message.MentionIds.Set(mentionIDs))
+	}
+
+	create := prisma.Client.Message.CreateOne(
 		db.Message.Content.Set(data.Content),
 		db.Message.Channel.Link(
 			db.Channel.ID.Equals(data.ChannelID),
@@ -26,30 +102,262 @@ func (s *MessageService) SendMessage(data types.MessageD) (*db.MessageModel, err
 			db.User.ID.Equals(data.SenderID),
 		),
 
-		db.Message.ReplyToUserName.Set(data.ReplyToUserName),
-		db.Message.ReplyToMessage.Set(data.ReplyToMessage),
-
 		db.Message.AttachmentLink.Set(data.AttachmentLink),
 		db.Message.AttachmentTitle.Set(data.AttachmentTitle),
-		db.Message.IsReply.Set(data.IsReply),
-	).Exec(context.Background())
-	if err != nil {
+		optional...,
+	).Tx()
+
+	// a reply and the reply count of its root are saved together
+	txs := []db.PrismaTransaction{create}
+	if data.ParentID != "" {
+		txs = append(txs, prisma.Client.Message.FindUnique(
+			db.Message.ID.Equals(data.ParentID),
+		).Update(
+			db.Message.ReplyCount.Increment(1),
+		).Tx())
+	}
+	if err := prisma.Client.Prisma.Transaction(txs...).Exec(ctx); err != nil {
 		return nil, fmt.Errorf("failed to send message: %v", err)
 	}
+	message := create.Result()
+
+	s.indexMessage(message)
+	go emitMessageWebhook(WebhookMessageCreated, message)
 
 	return message, nil
 }
 
-func (s *MessageService) GetMessagesByChannel(channelID string, page int) ([]db.MessageModel, error) {
-	messages, err := prisma.Client.Message.FindMany(
+// emitMessageWebhook emits a message event to the webhooks of the message's
+// workspace.
+func emitMessageWebhook(event string, message *db.MessageModel) {
+	channel, err := prisma.Client.Channel.FindUnique(
+		db.Channel.ID.Equals(message.ChannelID),
+	).Exec(context.Background())
+	if err != nil {
+		logger.LogError().Msgf("Failed to load channel %s for webhooks: %v", message.ChannelID, err)
+		return
+	}
+	// webhooks are workspace wide, so only public channels are sent out
+	if channel.Kind != db.ChannelKindPublic {
+		return
+	}
+	emitWebhook(channel.WorkspaceID, event, message)
+}
+
+// indexMessage keeps the search index in sync with a saved message. Failing
+// to index never fails the write itself.
+func (s *MessageService) indexMessage(message *db.MessageModel) {
+	channel, err := prisma.Client.Channel.FindUnique(
+		db.Channel.ID.Equals(message.ChannelID),
+	).Exec(context.Background())
+	if err != nil {
+		logger.LogError().Msgf("Failed to index message %s: %v", message.ID, err)
+		return
+	}
+
+	err = search.IndexMessage(search.MessageDoc{
+		ID:              message.ID,
+		WorkspaceID:     channel.WorkspaceID,
+		ChannelID:       message.ChannelID,
+		SenderID:        message.SenderID,
+		Content:         message.Content,
+		AttachmentTitle: message.AttachmentTitle,
+		HasAttachment:   message.AttachmentLink != "",
+		IsPinned:        message.IsPined,
+		CreatedAt:       message.CreatedAt,
+	})
+	if err != nil {
+		logger.LogError().Msgf("Failed to index message %s: %v", message.ID, err)
+	}
+}
+
+// GetMessagesByChannel returns a page of messages ordered by creation time,
+// positioned before, after or around an anchor message, or at the end of the
+// channel when no anchor is given, if the user can read the channel.
+func (s *MessageService) GetMessagesByChannel(userID, channelID string, cursor types.MessageCursorD) (*MessagesPage, error) {
+	if err := readableChannel(userID, channelID); err != nil {
+		return nil, err
+	}
+
+	limit := cursor.Limit
+	if limit <= 0 {
+		limit = defaultMessagesLimit
+	}
+	if limit > maxMessagesLimit {
+		limit = maxMessagesLimit
+	}
+
+	for _, anchor := range []string{cursor.Before, cursor.After, cursor.Around} {
+		if anchor == "" {
+			continue
+		}
+		_, err := prisma.Client.Message.FindFirst(
+			db.Message.ID.Equals(anchor),
+			db.Message.ChannelID.Equals(channelID),
+		).Exec(context.Background())
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, ErrAnchorNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		messages           []db.MessageModel
+		hasOlder, hasNewer bool
+	)
+	switch {
+	case cursor.Before != "":
+		older, err := s.fetchMessages(channelID, cursor.Before, db.SortOrderDesc, 1, limit+1)
+		if err != nil {
+			return nil, err
+		}
+		hasOlder, hasNewer = len(older) > limit, true
+		messages = reverseMessages(trimMessages(older, limit))
+
+	case cursor.After != "":
+		newer, err := s.fetchMessages(channelID, cursor.After, db.SortOrderAsc, 1, limit+1)
+		if err != nil {
+			return nil, err
+		}
+		hasOlder, hasNewer = true, len(newer) > limit
+		messages = trimMessages(newer, limit)
+
+	case cursor.Around != "":
+		half := limit / 2
+		older, err := s.fetchMessages(channelID, cursor.Around, db.SortOrderDesc, 1, half+1)
+		if err != nil {
+			return nil, err
+		}
+		// the anchor itself opens the newer half
+		newer, err := s.fetchMessages(channelID, cursor.Around, db.SortOrderAsc, 0, limit-half+1)
+		if err != nil {
+			return nil, err
+		}
+		hasOlder, hasNewer = len(older) > half, len(newer) > limit-half
+		messages = append(reverseMessages(trimMessages(older, half)), trimMessages(newer, limit-half)...)
+
+	default:
+		latest, err := s.fetchMessages(channelID, "", db.SortOrderDesc, 0, limit+1)
+		if err != nil {
+			return nil, err
+		}
+		hasOlder = len(latest) > limit
+		messages = reverseMessages(trimMessages(latest, limit))
+	}
+
+	withReactions, err := s.withReactions(messages)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &MessagesPage{Messages: withReactions}
+	if len(messages) > 0 {
+		if hasOlder {
+			page.PrevCursor = messages[0].ID
+		}
+		if hasNewer {
+			page.NextCursor = messages[len(messages)-1].ID
+		}
+	}
+	return page, nil
+}
+
+// fetchMessages reads up to take messages of a channel walking in the given
+// order from the anchor message, or from the start when anchor is empty.
+func (s *MessageService) fetchMessages(channelID, anchor string, order db.SortOrder, skip, take int) ([]db.MessageModel, error) {
+	query := prisma.Client.Message.FindMany(
 		db.Message.ChannelID.Equals(channelID),
-	).Skip((page - 1) * 10000).Take(10000).Exec(context.Background())
+	).With(
+		db.Message.Parent.Fetch(),
+	).OrderBy(
+		db.Message.CreatedAt.Order(order),
+		db.Message.ID.Order(order),
+	)
+	if anchor != "" {
+		query = query.Cursor(db.Message.ID.Cursor(anchor))
+	}
+
+	messages, err := query.Skip(skip).Take(take).Exec(context.Background())
 	if err != nil {
 		return nil, err
 	}
 	return messages, nil
 }
-func (s *MessageService) GetAttachmentsByChannel(channelID string) ([]db.AttachmentModel, error) {
+
+func trimMessages(messages []db.MessageModel, limit int) []db.MessageModel {
+	if len(messages) > limit {
+		return messages[:limit]
+	}
+	return messages
+}
+
+func reverseMessages(messages []db.MessageModel) []db.MessageModel {
+	reversed := make([]db.MessageModel, len(messages))
+	for i, message := range messages {
+		reversed[len(messages)-1-i] = message
+	}
+	return reversed
+}
+
+func (s *MessageService) withReactions(messages []db.MessageModel) ([]MessageWithReactions, error) {
+	messageIDs := make([]string, 0, len(messages))
+	for _, message := range messages {
+		messageIDs = append(messageIDs, message.ID)
+	}
+
+	reactions, err := prisma.Client.Reaction.FindMany(
+		db.Reaction.MessageID.In(messageIDs),
+	).OrderBy(
+		db.Reaction.CreatedAt.Order(db.SortOrderAsc),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	counts := countReactions(reactions)
+
+	result := make([]MessageWithReactions, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, MessageWithReactions{
+			MessageModel:   message,
+			ReactionCounts: counts[message.ID],
+		})
+	}
+	return result, nil
+}
+
+// countReactions groups reactions by message and emoji, keeping emojis in the
+// order they were first used on each message.
+func countReactions(reactions []db.ReactionModel) map[string][]types.ReactionCount {
+	counts := make(map[string][]types.ReactionCount)
+	for _, reaction := range reactions {
+		found := false
+		for i := range counts[reaction.MessageID] {
+			count := &counts[reaction.MessageID][i]
+			if count.Emoji == reaction.Emoji {
+				count.Count++
+				count.UserWorkspaceIDs = append(count.UserWorkspaceIDs, reaction.UserWorkspaceID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			counts[reaction.MessageID] = append(counts[reaction.MessageID], types.ReactionCount{
+				Emoji:            reaction.Emoji,
+				Count:            1,
+				UserWorkspaceIDs: []string{reaction.UserWorkspaceID},
+			})
+		}
+	}
+	return counts
+}
+
+func (s *MessageService) GetAttachmentsByChannel(userID, channelID string) ([]db.AttachmentModel, error) {
+	if err := readableChannel(userID, channelID); err != nil {
+		return nil, err
+	}
+
 	attachments, err := prisma.Client.Attachment.FindMany(
 		db.Attachment.ChannelID.Equals(channelID),
 	).Exec(context.Background())
@@ -70,20 +378,385 @@ func (s *MessageService) GetMessageById(messageID string) (*db.MessageModel, err
 	return message, nil
 }
 
-func (s *MessageService) DeleteMessage(userId, messageID string) error {
-	_, err := prisma.Client.Message.FindMany(
+// GetMentions lists the latest messages of a workspace mentioning the user,
+// newest first.
+func (s *MessageService) GetMentions(userID, workspaceID string, limit int) ([]db.MessageModel, error) {
+	ctx := context.Background()
+
+	if limit <= 0 || limit > maxMessagesLimit {
+		limit = defaultMessagesLimit
+	}
+
+	user, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userID),
+		db.UserWorkspace.WorkspaceID.Equals(workspaceID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("user is not part of the workspace: %v", err)
+	}
+
+	messages, err := prisma.Client.Message.FindMany(
+		db.Message.MentionIds.Has(user.ID),
+	).With(
+		db.Message.Channel.Fetch(),
+	).OrderBy(
+		db.Message.CreatedAt.Order(db.SortOrderDesc),
+	).Take(limit).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// GetThread returns a message together with its replies, oldest first, if
+// the user participates in its channel.
+func (s *MessageService) GetThread(userID, messageID string) (*db.MessageModel, error) {
+	message, err := prisma.Client.Message.FindUnique(
+		db.Message.ID.Equals(messageID),
+	).With(
+		db.Message.Replies.Fetch().OrderBy(
+			db.Message.CreatedAt.Order(db.SortOrderAsc),
+		),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := channelMember(userID, message.ChannelID); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// DeleteMessage deletes a message sent by userId. A message with replies is
+// emptied and kept as a "message deleted" root so the replies of the other
+// participants stay in place, and is removed along with its last reply. The
+// returned message is that tombstone, or nil when the message is gone.
+func (s *MessageService) DeleteMessage(userId, messageID string) (*db.MessageModel, error) {
+	ctx := context.Background()
+
+	message, err := prisma.Client.Message.FindFirst(
 		db.Message.ID.Equals(messageID),
 		db.Message.SenderID.Equals(userId),
-	).Delete().Exec(context.Background())
+	).Exec(ctx)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if message.IsDeleted {
+		return nil, errors.New("message was already deleted")
 	}
 
+	var tombstone *db.MessageModel
+	if message.ReplyCount > 0 {
+		tombstone, err = s.tombstone(message.ID)
+	} else {
+		err = s.removeMessage(message)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := search.DeleteMessages(message.ID); err != nil {
+		logger.LogError().Msgf("Failed to remove message %s from the search index: %v", message.ID, err)
+	}
+	go emitMessageWebhook(WebhookMessageDeleted, message)
+
+	return tombstone, nil
+}
+
+// tombstone empties a message, dropping its revisions and reactions.
+func (s *MessageService) tombstone(messageID string) (*db.MessageModel, error) {
+	update := prisma.Client.Message.FindUnique(
+		db.Message.ID.Equals(messageID),
+	).Update(
+		db.Message.IsDeleted.Set(true),
+		db.Message.Content.Set(""),
+		db.Message.AttachmentLink.Set(""),
+		db.Message.AttachmentTitle.Set(""),
+		db.Message.MentionIds.Set([]string{}),
+		db.Message.IsPined.Set(false),
+	).Tx()
+
+	err := prisma.Client.Prisma.Transaction(
+		update,
+		prisma.Client.MessageEdit.FindMany(
+			db.MessageEdit.MessageID.Equals(messageID),
+		).Delete().Tx(),
+		prisma.Client.Reaction.FindMany(
+			db.Reaction.MessageID.Equals(messageID),
+		).Delete().Tx(),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete message: %v", err)
+	}
+	return update.Result(), nil
+}
+
+// removeMessage deletes a message without replies, decrementing the reply
+// count of its root. A deleted root goes away with its last reply.
+func (s *MessageService) removeMessage(message *db.MessageModel) error {
+	ctx := context.Background()
+
+	txs := []db.PrismaTransaction{
+		prisma.Client.Message.FindUnique(
+			db.Message.ID.Equals(message.ID),
+		).Delete().Tx(),
+	}
+	parentID, isReply := message.ParentID()
+	var parent db.MessageUniqueTxResult
+	if isReply {
+		parent = prisma.Client.Message.FindUnique(
+			db.Message.ID.Equals(parentID),
+		).Update(
+			db.Message.ReplyCount.Decrement(1),
+		).Tx()
+		txs = append(txs, parent)
+	}
+	if err := prisma.Client.Prisma.Transaction(txs...).Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete message: %v", err)
+	}
+
+	if isReply {
+		if root := parent.Result(); root.IsDeleted && root.ReplyCount <= 0 {
+			_, err := prisma.Client.Message.FindUnique(
+				db.Message.ID.Equals(root.ID),
+			).Delete().Exec(ctx)
+			if err != nil {
+				logger.LogError().Msgf("Failed to remove deleted message %s: %v", root.ID, err)
+			}
+		}
+	}
 	return nil
 }
 
+// EditMessage replaces the content of a message sent by userId, keeping the
+// previous content as a revision. Mentions are resolved again, and the user
+// workspaces the edit mentions for the first time are returned alongside.
+func (s *MessageService) EditMessage(userId, messageID, content string) (*db.MessageModel, []string, error) {
+	ctx := context.Background()
+
+	message, err := prisma.Client.Message.FindFirst(
+		db.Message.ID.Equals(messageID),
+		db.Message.SenderID.Equals(userId),
+	).Exec(ctx)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, nil, errors.New("only the sender can edit this message")
+		}
+		return nil, nil, err
+	}
+	if message.IsDeleted {
+		return nil, nil, errors.New("message was deleted")
+	}
+
+	if message.Content == content {
+		return message, nil, nil
+	}
+	if err := checkWritable(message.ChannelID); err != nil {
+		return nil, nil, err
+	}
+
+	mentionIDs, err := resolveMentions(message.ChannelID, userId, content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve mentions: %v", err)
+	}
+	var added []string
+	for _, id := range mentionIDs {
+		if !slices.Contains(message.MentionIds, id) {
+			added = append(added, id)
+		}
+	}
+	if mentionIDs == nil {
+		mentionIDs = []string{}
+	}
+
+	// the revision and the new content are saved together
+	update := prisma.Client.Message.FindUnique(
+		db.Message.ID.Equals(message.ID),
+	).Update(
+		db.Message.Content.Set(content),
+		db.Message.EditedAt.Set(time.Now()),
+		db.Message.MentionIds.Set(mentionIDs),
+	).Tx()
+	err = prisma.Client.Prisma.Transaction(
+		prisma.Client.MessageEdit.CreateOne(
+			db.MessageEdit.Message.Link(
+				db.Message.ID.Equals(message.ID),
+			),
+			db.MessageEdit.Content.Set(message.Content),
+		).Tx(),
+		update,
+	).Exec(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to edit message: %v", err)
+	}
+	updated := update.Result()
+
+	s.indexMessage(updated)
+
+	return updated, added, nil
+}
+
+// GetMessageEdits lists the previous revisions of a message, oldest first, if
+// the user participates in its channel.
+func (s *MessageService) GetMessageEdits(userID, messageID string) ([]db.MessageEditModel, error) {
+	message, err := s.GetMessageById(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := channelMember(userID, message.ChannelID); err != nil {
+		return nil, err
+	}
+
+	edits, err := prisma.Client.MessageEdit.FindMany(
+		db.MessageEdit.MessageID.Equals(messageID),
+	).OrderBy(
+		db.MessageEdit.CreatedAt.Order(db.SortOrderAsc),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return edits, nil
+}
+
+// reactingUser resolves the user workspace a user reacts with on a message,
+// which is their membership in the workspace owning the message's channel.
+func (s *MessageService) reactingUser(userId, messageID string) (*db.UserWorkspaceModel, error) {
+	ctx := context.Background()
+
+	message, err := prisma.Client.Message.FindUnique(
+		db.Message.ID.Equals(messageID),
+	).With(db.Message.Channel.Fetch()).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("message not found: %v", err)
+	}
+	if message.IsDeleted {
+		return nil, errors.New("message was deleted")
+	}
+	if message.Channel().IsArchived {
+		return nil, ErrChannelArchived
+	}
+
+	user, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userId),
+		db.UserWorkspace.WorkspaceID.Equals(message.Channel().WorkspaceID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("user is not part of the workspace: %v", err)
+	}
+	return user, nil
+}
+
+// AddReaction reacts to a message with an emoji. Reacting twice with the same
+// emoji returns the existing reaction.
+func (s *MessageService) AddReaction(userId, messageID, emoji string) (*db.ReactionModel, error) {
+	user, err := s.reactingUser(userId, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return s.addReaction(user.ID, messageID, emoji)
+}
+
+// RemoveReaction removes the user's reaction with the given emoji and returns
+// the user workspace it belonged to.
+func (s *MessageService) RemoveReaction(userId, messageID, emoji string) (*db.UserWorkspaceModel, error) {
+	user, err := s.reactingUser(userId, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.removeReaction(user.ID, messageID, emoji); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ToggleReaction adds the reaction if the user hasn't reacted with that emoji
+// yet and removes it otherwise. It reports whether the reaction was added.
+func (s *MessageService) ToggleReaction(userId, messageID, emoji string) (string, bool, error) {
+	user, err := s.reactingUser(userId, messageID)
+	if err != nil {
+		return "", false, err
+	}
+
+	removed, err := s.removeReaction(user.ID, messageID, emoji)
+	if err != nil {
+		return "", false, err
+	}
+	if removed {
+		return user.ID, false, nil
+	}
+
+	_, err = s.addReaction(user.ID, messageID, emoji)
+	return user.ID, true, err
+}
+
+// addReaction creates the reaction, which the unique index on the message,
+// user workspace and emoji keeps from being saved twice.
+func (s *MessageService) addReaction(userWorkspaceID, messageID, emoji string) (*db.ReactionModel, error) {
+	ctx := context.Background()
+
+	reaction, err := prisma.Client.Reaction.CreateOne(
+		db.Reaction.Emoji.Set(emoji),
+		db.Reaction.Message.Link(
+			db.Message.ID.Equals(messageID),
+		),
+		db.Reaction.UserWorkspace.Link(
+			db.UserWorkspace.ID.Equals(userWorkspaceID),
+		),
+	).Exec(ctx)
+	if _, exists := db.IsErrUniqueConstraint(err); exists {
+		return prisma.Client.Reaction.FindUnique(
+			db.Reaction.MessageIDUserWorkspaceIDEmoji(
+				db.Reaction.MessageID.Equals(messageID),
+				db.Reaction.UserWorkspaceID.Equals(userWorkspaceID),
+				db.Reaction.Emoji.Equals(emoji),
+			),
+		).Exec(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to add reaction: %v", err)
+	}
+	return reaction, nil
+}
+
+// removeReaction deletes the reaction and reports whether there was one.
+func (s *MessageService) removeReaction(userWorkspaceID, messageID, emoji string) (bool, error) {
+	result, err := prisma.Client.Reaction.FindMany(
+		db.Reaction.MessageID.Equals(messageID),
+		db.Reaction.UserWorkspaceID.Equals(userWorkspaceID),
+		db.Reaction.Emoji.Equals(emoji),
+	).Delete().Exec(context.Background())
+	if err != nil {
+		return false, err
+	}
+	return result.Count > 0, nil
+}
+
+// GetReactions lists the reactions on a message, oldest first, if the user
+// participates in its channel.
+func (s *MessageService) GetReactions(userID, messageID string) ([]db.ReactionModel, error) {
+	message, err := s.GetMessageById(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := channelMember(userID, message.ChannelID); err != nil {
+		return nil, err
+	}
+
+	reactions, err := prisma.Client.Reaction.FindMany(
+		db.Reaction.MessageID.Equals(messageID),
+	).OrderBy(
+		db.Reaction.CreatedAt.Order(db.SortOrderAsc),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return reactions, nil
+}
+
 func (s *MessageService) PingMessage(messageID string) error {
-	_, err := prisma.Client.Message.FindUnique(
+	message, err := prisma.Client.Message.FindUnique(
 		db.Message.ID.Equals(messageID),
 	).Update(
 		db.Message.IsPined.Set(true),
@@ -93,11 +766,17 @@ func (s *MessageService) PingMessage(messageID string) error {
 		return err
 	}
 
+	s.indexMessage(message)
+
 	return nil
 
 }
 
-func (s *MessageService) GetPinnedMessages(channelID string, page int) ([]db.MessageModel, error) {
+func (s *MessageService) GetPinnedMessages(userID, channelID string, page int) ([]db.MessageModel, error) {
+	if err := readableChannel(userID, channelID); err != nil {
+		return nil, err
+	}
+
 	result, err := prisma.Client.Message.FindMany(
 		db.Message.ChannelID.Equals(channelID),
 		db.Message.IsPined.Equals(true),
@@ -135,3 +814,17 @@ func (s *MessageService) DeleteAttachment(userId, attachmentID string) error {
 
 	return nil
 }
+
+// checkWritable fails when the channel is archived and so read-only.
+func checkWritable(channelID string) error {
+	channel, err := prisma.Client.Channel.FindUnique(
+		db.Channel.ID.Equals(channelID),
+	).Exec(context.Background())
+	if err != nil {
+		return fmt.Errorf("channel not found: %v", err)
+	}
+	if channel.IsArchived {
+		return ErrChannelArchived
+	}
+	return nil
+}
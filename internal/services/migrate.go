@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
+	"github.com/CollabTED/CollabTed-Backend/prisma"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+// fieldDefault is a field added to a model after documents were stored
+// without it. Prisma only applies @default on insert, and filters such as
+// isArchived = false don't match a missing field.
+type fieldDefault struct {
+	collection string
+	field      string
+	value      any
+}
+
+var fieldDefaults = []fieldDefault{
+	// every channel was listed in its workspace before channels had a kind
+	{"Channel", "kind", "PUBLIC"},
+	{"Channel", "isArchived", false},
+	{"Message", "isSystem", false},
+	{"Message", "isDeleted", false},
+	{"Message", "replyCount", 0},
+	{"Message", "mentionIds", []string{}},
+	{"UserWorkspace", "presence", "ACTIVE"},
+	{"UserWorkspace", "statusText", ""},
+	{"User", "isBot", false},
+}
+
+// legacyReplyBatch is how many replies stored before threads are linked at
+// once.
+const legacyReplyBatch = 200
+
+// Migrate sets the default of every field in fieldDefaults on the documents
+// lacking it, then links the replies stored before threads. It only touches
+// documents that still need it, so every replica can run it at startup.
+func Migrate() {
+	for _, d := range fieldDefaults {
+		if err := setMissing(d); err != nil {
+			logger.LogError().Msgf("Failed to backfill %s.%s: %v", d.collection, d.field, err)
+		}
+	}
+	if err := migrateLegacyReplies(); err != nil {
+		logger.LogError().Msgf("Failed to link legacy replies: %v", err)
+	}
+}
+
+func setMissing(d fieldDefault) error {
+	// the command name has to come first, json sorts "update" before "updates"
+	cmd, err := json.Marshal(map[string]any{
+		"update": d.collection,
+		"updates": []map[string]any{{
+			"q":     map[string]any{d.field: map[string]any{"$exists": false}},
+			"u":     map[string]any{"$set": map[string]any{d.field: d.value}},
+			"multi": true,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	var result any
+	return runCommand(cmd, &result)
+}
+
+// legacyReply is a reply stored before threads, which only quoted the content
+// and the author name of its parent.
+type legacyReply struct {
+	ID              objectID `json:"_id"`
+	ChannelID       objectID `json:"channelID"`
+	CreatedAt       string   `json:"createdAt"`
+	ReplyToMessage  string   `json:"replyToMessage"`
+	ReplyToUserName string   `json:"replyToUserName"`
+}
+
+// legacyParent is a message a legacy reply may have quoted.
+type legacyParent struct {
+	ID       objectID  `json:"_id"`
+	SenderID objectID  `json:"senderID"`
+	ParentID *objectID `json:"parentId"`
+}
+
+// migrateLegacyReplies links every legacy reply to the latest earlier message
+// of its channel with the quoted content and author, or to the root of its
+// thread. Replies whose parent is gone stop being replies and keep their
+// quote. Either way they no longer match, so the loop ends.
+func migrateLegacyReplies() error {
+	for {
+		var replies []legacyReply
+		err := aggregate("Message", []map[string]any{
+			{"$match": map[string]any{
+				"isReply":        true,
+				"parentId":       map[string]any{"$exists": false},
+				"replyToMessage": map[string]any{"$exists": true},
+			}},
+			{"$limit": legacyReplyBatch},
+			{"$project": map[string]any{
+				"channelID":       1,
+				"replyToMessage":  1,
+				"replyToUserName": 1,
+				// a plain string, whatever the extended JSON mode of replies
+				"createdAt": map[string]any{"$dateToString": map[string]any{
+					"date":   "$createdAt",
+					"format": "%Y-%m-%dT%H:%M:%S.%LZ",
+				}},
+			}},
+		}, legacyReplyBatch, &replies)
+		if err != nil {
+			return err
+		}
+		if len(replies) == 0 {
+			return nil
+		}
+		for _, reply := range replies {
+			if err := linkLegacyReply(reply); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func linkLegacyReply(reply legacyReply) error {
+	createdAt, err := time.Parse(time.RFC3339Nano, reply.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	var candidates []legacyParent
+	err = aggregate("Message", []map[string]any{
+		{"$match": map[string]any{
+			"channelID": reply.ChannelID,
+			"content":   reply.ReplyToMessage,
+			"createdAt": map[string]any{"$lt": mongoDate{createdAt}},
+		}},
+		{"$sort": map[string]any{"createdAt": -1}},
+		{"$limit": 20},
+		{"$project": map[string]any{"senderID": 1, "parentId": 1}},
+	}, 20, &candidates)
+	if err != nil {
+		return err
+	}
+
+	var parent *legacyParent
+	for i, candidate := range candidates {
+		sender, err := prisma.Client.User.FindUnique(
+			db.User.ID.Equals(candidate.SenderID.OID),
+		).Exec(context.Background())
+		if err == nil && sender.Name == reply.ReplyToUserName {
+			parent = &candidates[i]
+			break
+		}
+	}
+
+	updates := []map[string]any{{
+		"q": map[string]any{"_id": reply.ID},
+		"u": map[string]any{"$set": map[string]any{"isReply": false}},
+	}}
+	if parent != nil {
+		// threads are one level deep
+		root := parent.ID
+		if parent.ParentID != nil {
+			root = *parent.ParentID
+		}
+		updates = []map[string]any{{
+			"q": map[string]any{"_id": reply.ID},
+			"u": map[string]any{
+				"$set":   map[string]any{"parentId": root},
+				"$unset": map[string]any{"replyToMessage": "", "replyToUserName": ""},
+			},
+		}, {
+			"q": map[string]any{"_id": root},
+			"u": map[string]any{"$inc": map[string]any{"replyCount": 1}},
+		}}
+	}
+
+	// the command name has to come first, json sorts "update" before "updates"
+	cmd, err := json.Marshal(map[string]any{
+		"update":  "Message",
+		"updates": updates,
+	})
+	if err != nil {
+		return err
+	}
+	var result any
+	return runCommand(cmd, &result)
+}
+
+// aggregate runs a pipeline expected to return at most batchSize documents
+// and decodes them into v.
+func aggregate(collection string, pipeline []map[string]any, batchSize int, v any) error {
+	// the command name has to come first, json sorts "aggregate" before
+	// "cursor" and "pipeline"
+	cmd, err := json.Marshal(map[string]any{
+		"aggregate": collection,
+		"pipeline":  pipeline,
+		"cursor":    map[string]any{"batchSize": batchSize},
+	})
+	if err != nil {
+		return err
+	}
+
+	var reply struct {
+		Cursor struct {
+			FirstBatch json.RawMessage `json:"firstBatch"`
+		} `json:"cursor"`
+	}
+	if err := runCommand(cmd, &reply); err != nil {
+		return err
+	}
+	return json.Unmarshal(reply.Cursor.FirstBatch, v)
+}
+
+// runCommand runs a raw MongoDB command, for what the Prisma client can't
+// express, and decodes its reply into v. The command is extended JSON.
+func runCommand(cmd []byte, v any) error {
+	return prisma.Client.Prisma.RunCommandRaw(string(cmd)).Exec(context.Background(), v)
+}
+
+// objectID is an ObjectId in extended JSON, as commands take and return them.
+type objectID struct {
+	OID string `json:"$oid"`
+}
+
+// mongoDate is a date in extended JSON.
+type mongoDate struct {
+	Date time.Time `json:"$date"`
+}
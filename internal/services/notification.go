@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/prisma"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+const notificationsPageSize = 20
+
+type NotificationService struct{}
+
+func NewNotificationService() *NotificationService {
+	return &NotificationService{}
+}
+
+type NotificationsPage struct {
+	Notifications []db.NotificationModel `json:"notifications"`
+	UnreadCount   int                    `json:"unreadCount"`
+	Page          int                    `json:"page"`
+}
+
+// CreateNotification stores a notification in the user's inbox, data is the
+// JSON payload published to the user.
+func (s *NotificationService) CreateNotification(userID, notifType string, data []byte) (*db.NotificationModel, error) {
+	return prisma.Client.Notification.CreateOne(
+		db.Notification.UserID.Set(userID),
+		db.Notification.Type.Set(notifType),
+		db.Notification.Data.Set(data),
+	).Exec(context.Background())
+}
+
+// ListNotifications returns a page of the user's inbox, newest first, along
+// with the total number of unread notifications.
+func (s *NotificationService) ListNotifications(userID string, page int, unreadOnly bool) (*NotificationsPage, error) {
+	ctx := context.Background()
+	if page < 1 {
+		page = 1
+	}
+
+	filters := []db.NotificationWhereParam{
+		db.Notification.UserID.Equals(userID),
+	}
+	if unreadOnly {
+		filters = append(filters, db.Notification.IsRead.Equals(false))
+	}
+
+	notifications, err := prisma.Client.Notification.FindMany(
+		filters...,
+	).OrderBy(
+		db.Notification.CreatedAt.Order(db.SortOrderDesc),
+	).Skip((page - 1) * notificationsPageSize).Take(notificationsPageSize).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	unread, err := countUnreadNotifications(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NotificationsPage{
+		Notifications: notifications,
+		UnreadCount:   unread,
+		Page:          page,
+	}, nil
+}
+
+// countUnreadNotifications counts the user's unread notifications on the
+// server, the Prisma client can only count by loading them.
+func countUnreadNotifications(userID string) (int, error) {
+	cmd, err := json.Marshal(map[string]any{
+		"count": "Notification",
+		"query": map[string]any{
+			"userId": objectID{userID},
+			"isRead": false,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var reply struct {
+		N int `json:"n"`
+	}
+	if err := runCommand(cmd, &reply); err != nil {
+		return 0, err
+	}
+	return reply.N, nil
+}
+
+func (s *NotificationService) MarkRead(userID, notificationID string) (*db.NotificationModel, error) {
+	ctx := context.Background()
+
+	notification, err := prisma.Client.Notification.FindFirst(
+		db.Notification.ID.Equals(notificationID),
+		db.Notification.UserID.Equals(userID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if notification.IsRead {
+		return notification, nil
+	}
+
+	return prisma.Client.Notification.FindUnique(
+		db.Notification.ID.Equals(notification.ID),
+	).Update(
+		db.Notification.IsRead.Set(true),
+		db.Notification.ReadAt.Set(time.Now()),
+	).Exec(ctx)
+}
+
+// MarkAllRead marks every unread notification of the user as read and
+// returns how many were updated.
+func (s *NotificationService) MarkAllRead(userID string) (int, error) {
+	res, err := prisma.Client.Notification.FindMany(
+		db.Notification.UserID.Equals(userID),
+		db.Notification.IsRead.Equals(false),
+	).Update(
+		db.Notification.IsRead.Set(true),
+		db.Notification.ReadAt.Set(time.Now()),
+	).Exec(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return res.Count, nil
+}
@@ -0,0 +1,406 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+const clockLayout = "15:04"
+
+type NotificationPreferenceService struct{}
+
+func NewNotificationPreferenceService() *NotificationPreferenceService {
+	return &NotificationPreferenceService{}
+}
+
+type NotificationPreferences struct {
+	Preferences []db.NotificationPreferenceModel `json:"preferences"`
+	QuietHours  *db.QuietHoursModel              `json:"quietHours"`
+}
+
+// NotifyDecision tells how a notification should reach a user.
+type NotifyDecision struct {
+	// Notify is false when the notification is filtered out by the user's
+	// level for the channel, it is then neither stored nor pushed.
+	Notify bool
+	// Quiet is true during the user's quiet hours or while they are in do not
+	// disturb, the notification is stored in the inbox but not pushed.
+	Quiet bool
+}
+
+func (s *NotificationPreferenceService) GetPreferences(userID string) (*NotificationPreferences, error) {
+	ctx := context.Background()
+
+	prefs, err := prisma.Client.NotificationPreference.FindMany(
+		db.NotificationPreference.UserID.Equals(userID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	quiet, err := prisma.Client.QuietHours.FindUnique(
+		db.QuietHours.UserID.Equals(userID),
+	).Exec(ctx)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+	return &NotificationPreferences{Preferences: prefs, QuietHours: quiet}, nil
+}
+
+// SetPreference sets the level of the user's default, of a workspace, or of
+// a channel when data.ChannelID is set.
+func (s *NotificationPreferenceService) SetPreference(userID string, data types.NotificationPreferenceD) (*db.NotificationPreferenceModel, error) {
+	ctx := context.Background()
+
+	level := db.NotifyLevel(data.Level)
+	switch level {
+	case db.NotifyLevelAll, db.NotifyLevelMentions, db.NotifyLevelMuted:
+	default:
+		return nil, fmt.Errorf("invalid level: %s", data.Level)
+	}
+
+	workspaceID, err := preferenceScope(userID, data.WorkspaceID, data.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := findPreference(userID, workspaceID, data.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return prisma.Client.NotificationPreference.FindUnique(
+			db.NotificationPreference.ID.Equals(existing.ID),
+		).Update(
+			db.NotificationPreference.Level.Set(level),
+		).Exec(ctx)
+	}
+
+	optional := []db.NotificationPreferenceSetParam{
+		db.NotificationPreference.Level.Set(level),
+	}
+	if workspaceID != "" {
+		optional = append(optional, db.NotificationPreference.WorkspaceID.Set(workspaceID))
+	}
+	if data.ChannelID != "" {
+		optional = append(optional, db.NotificationPreference.ChannelID.Set(data.ChannelID))
+	}
+	return prisma.Client.NotificationPreference.CreateOne(
+		db.NotificationPreference.UserID.Set(userID),
+		optional...,
+	).Exec(ctx)
+}
+
+// ResetPreference removes a workspace or channel preference so that it falls
+// back to the broader one again.
+func (s *NotificationPreferenceService) ResetPreference(userID, workspaceID, channelID string) error {
+	existing, err := findPreference(userID, workspaceID, channelID)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return errors.New("preference not found")
+	}
+
+	_, err = prisma.Client.NotificationPreference.FindUnique(
+		db.NotificationPreference.ID.Equals(existing.ID),
+	).Delete().Exec(context.Background())
+	return err
+}
+
+func (s *NotificationPreferenceService) SetQuietHours(userID string, data types.QuietHoursD) (*db.QuietHoursModel, error) {
+	ctx := context.Background()
+
+	if _, err := time.LoadLocation(data.Timezone); err != nil || data.Timezone == "" {
+		return nil, fmt.Errorf("invalid timezone: %s", data.Timezone)
+	}
+	start, err := time.Parse(clockLayout, data.Start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start, expected HH:MM: %s", data.Start)
+	}
+	end, err := time.Parse(clockLayout, data.End)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end, expected HH:MM: %s", data.End)
+	}
+	if start.Equal(end) {
+		return nil, errors.New("start and end can't be the same")
+	}
+
+	_, err = prisma.Client.QuietHours.FindUnique(
+		db.QuietHours.UserID.Equals(userID),
+	).Exec(ctx)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
+		return prisma.Client.QuietHours.CreateOne(
+			db.QuietHours.UserID.Set(userID),
+			db.QuietHours.Start.Set(data.Start),
+			db.QuietHours.End.Set(data.End),
+			db.QuietHours.Timezone.Set(data.Timezone),
+			db.QuietHours.Enabled.Set(data.Enabled),
+		).Exec(ctx)
+	}
+
+	return prisma.Client.QuietHours.FindUnique(
+		db.QuietHours.UserID.Equals(userID),
+	).Update(
+		db.QuietHours.Start.Set(data.Start),
+		db.QuietHours.End.Set(data.End),
+		db.QuietHours.Timezone.Set(data.Timezone),
+		db.QuietHours.Enabled.Set(data.Enabled),
+	).Exec(ctx)
+}
+
+// Decide applies the user's preferences to a notification about channelID,
+// which may be empty for notifications outside of a channel. The most
+// specific level wins, from channel to workspace to the user's default.
+// Preferences that can't be loaded let the notification through.
+func (s *NotificationPreferenceService) Decide(userID, channelID string, mention bool, now time.Time) NotifyDecision {
+	return s.LoadPolicy([]string{userID}, channelID).Decide(userID, mention, now)
+}
+
+// LoadPolicy loads the preferences of all the users at once, for fanning a
+// notification about channelID out without querying per recipient.
+func (s *NotificationPreferenceService) LoadPolicy(userIDs []string, channelID string) *NotifyPolicy {
+	return loadPolicy(userIDs, channelWorkspace(channelID), channelID)
+}
+
+// Allows reports whether the user's level for the channel lets a message
+// through, for paths that aren't pushed such as the email digest.
+func (s *NotificationPreferenceService) Allows(userID, channelID string, mention bool) bool {
+	return allows(userID, channelWorkspace(channelID), channelID, mention)
+}
+
+func (s *NotificationPreferenceService) InQuietHours(userID string, now time.Time) bool {
+	return inQuietHours(userID, now)
+}
+
+// NotifyPolicy holds the preferences, quiet hours and presence of a set of
+// users for notifications about one channel.
+type NotifyPolicy struct {
+	workspaceID string
+	channelID   string
+	prefs       map[string][]db.NotificationPreferenceModel
+	quiet       map[string]db.QuietHoursModel
+	dnd         map[string]bool
+}
+
+func loadPolicy(userIDs []string, workspaceID, channelID string) *NotifyPolicy {
+	ctx := context.Background()
+	p := &NotifyPolicy{
+		workspaceID: workspaceID,
+		channelID:   channelID,
+		prefs:       make(map[string][]db.NotificationPreferenceModel, len(userIDs)),
+		quiet:       make(map[string]db.QuietHoursModel),
+		dnd:         make(map[string]bool),
+	}
+	if len(userIDs) == 0 {
+		return p
+	}
+
+	prefs, err := prisma.Client.NotificationPreference.FindMany(
+		db.NotificationPreference.UserID.In(userIDs),
+	).Exec(ctx)
+	if err != nil {
+		logger.LogError().Msgf("Failed to load notification preferences: %v", err)
+	}
+	for _, pref := range prefs {
+		p.prefs[pref.UserID] = append(p.prefs[pref.UserID], pref)
+	}
+
+	quiet, err := prisma.Client.QuietHours.FindMany(
+		db.QuietHours.UserID.In(userIDs),
+	).Exec(ctx)
+	if err != nil {
+		logger.LogError().Msgf("Failed to load quiet hours: %v", err)
+	}
+	for _, q := range quiet {
+		p.quiet[q.UserID] = q
+	}
+
+	if workspaceID == "" {
+		return p
+	}
+	members, err := prisma.Client.UserWorkspace.FindMany(
+		db.UserWorkspace.UserID.In(userIDs),
+		db.UserWorkspace.WorkspaceID.Equals(workspaceID),
+	).Exec(ctx)
+	if err != nil {
+		logger.LogError().Msgf("Failed to load the presence of workspace %s: %v", workspaceID, err)
+	}
+	for _, member := range members {
+		p.dnd[member.UserID] = member.Presence == db.PresenceDoNotDisturb
+	}
+	return p
+}
+
+// Decide tells how a notification should reach the user, who must be one of
+// the users the policy was loaded for.
+func (p *NotifyPolicy) Decide(userID string, mention bool, now time.Time) NotifyDecision {
+	if !levelAllows(resolveLevel(p.prefs[userID], p.workspaceID, p.channelID), mention) {
+		return NotifyDecision{}
+	}
+
+	quiet, ok := p.quiet[userID]
+	return NotifyDecision{
+		Notify: true,
+		Quiet:  (ok && quietAt(quiet, now)) || p.dnd[userID],
+	}
+}
+
+func allows(userID, workspaceID, channelID string, mention bool) bool {
+	prefs, err := prisma.Client.NotificationPreference.FindMany(
+		db.NotificationPreference.UserID.Equals(userID),
+	).Exec(context.Background())
+	if err != nil {
+		logger.LogError().Msgf("Failed to load notification preferences of %s: %v", userID, err)
+		return true
+	}
+	return levelAllows(resolveLevel(prefs, workspaceID, channelID), mention)
+}
+
+func levelAllows(level db.NotifyLevel, mention bool) bool {
+	switch level {
+	case db.NotifyLevelMuted:
+		return false
+	case db.NotifyLevelMentions:
+		return mention
+	}
+	return true
+}
+
+func channelWorkspace(channelID string) string {
+	if channelID == "" {
+		return ""
+	}
+	channel, err := prisma.Client.Channel.FindUnique(
+		db.Channel.ID.Equals(channelID),
+	).Exec(context.Background())
+	if err != nil {
+		return ""
+	}
+	return channel.WorkspaceID
+}
+
+// preferenceScope checks the user belongs to the workspace or channel a
+// preference is set for, and returns the workspace it falls under.
+func preferenceScope(userID, workspaceID, channelID string) (string, error) {
+	if channelID != "" {
+		channel, err := channelMember(userID, channelID)
+		if err != nil {
+			return "", err
+		}
+		return channel.WorkspaceID, nil
+	}
+	if workspaceID == "" {
+		return "", nil
+	}
+
+	_, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userID),
+		db.UserWorkspace.WorkspaceID.Equals(workspaceID),
+	).Exec(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("user is not part of the workspace: %v", err)
+	}
+	return workspaceID, nil
+}
+
+// findPreference returns the user's preference for exactly this scope, or
+// nil if there is none. Users have few preferences so they are matched here
+// rather than by filtering on unset fields.
+func findPreference(userID, workspaceID, channelID string) (*db.NotificationPreferenceModel, error) {
+	prefs, err := prisma.Client.NotificationPreference.FindMany(
+		db.NotificationPreference.UserID.Equals(userID),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	for i, pref := range prefs {
+		prefWorkspace, _ := pref.WorkspaceID()
+		prefChannel, _ := pref.ChannelID()
+		if channelID != "" && prefChannel == channelID {
+			return &prefs[i], nil
+		}
+		if channelID == "" && prefChannel == "" && prefWorkspace == workspaceID {
+			return &prefs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func resolveLevel(prefs []db.NotificationPreferenceModel, workspaceID, channelID string) db.NotifyLevel {
+	level := db.NotifyLevelAll
+	specificity := 0
+	for _, pref := range prefs {
+		prefWorkspace, _ := pref.WorkspaceID()
+		prefChannel, _ := pref.ChannelID()
+
+		rank := 0
+		switch {
+		case prefChannel != "":
+			if channelID != "" && prefChannel == channelID {
+				rank = 3
+			}
+		case prefWorkspace != "":
+			if workspaceID != "" && prefWorkspace == workspaceID {
+				rank = 2
+			}
+		default:
+			rank = 1
+		}
+		if rank > specificity {
+			level, specificity = pref.Level, rank
+		}
+	}
+	return level
+}
+
+func inQuietHours(userID string, now time.Time) bool {
+	quiet, err := prisma.Client.QuietHours.FindUnique(
+		db.QuietHours.UserID.Equals(userID),
+	).Exec(context.Background())
+	if err != nil {
+		return false
+	}
+	return quietAt(*quiet, now)
+}
+
+// quietAt reports whether now falls within the quiet hours.
+func quietAt(quiet db.QuietHoursModel, now time.Time) bool {
+	if !quiet.Enabled {
+		return false
+	}
+
+	loc, err := time.LoadLocation(quiet.Timezone)
+	if err != nil {
+		return false
+	}
+	start, err := time.Parse(clockLayout, quiet.Start)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse(clockLayout, quiet.End)
+	if err != nil {
+		return false
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	if from < to {
+		return minute >= from && minute < to
+	}
+	// spans midnight
+	return minute >= from || minute < to
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+func pref(level db.NotifyLevel, workspaceID, channelID string) db.NotificationPreferenceModel {
+	p := db.NotificationPreferenceModel{}
+	p.Level = level
+	if workspaceID != "" {
+		p.InnerNotificationPreference.WorkspaceID = &workspaceID
+	}
+	if channelID != "" {
+		p.InnerNotificationPreference.ChannelID = &channelID
+	}
+	return p
+}
+
+func TestResolveLevel(t *testing.T) {
+	tests := []struct {
+		name        string
+		prefs       []db.NotificationPreferenceModel
+		workspaceID string
+		channelID   string
+		want        db.NotifyLevel
+	}{
+		{"no preferences", nil, "w", "c", db.NotifyLevelAll},
+		{"default", []db.NotificationPreferenceModel{
+			pref(db.NotifyLevelMentions, "", ""),
+		}, "w", "c", db.NotifyLevelMentions},
+		{"workspace over default", []db.NotificationPreferenceModel{
+			pref(db.NotifyLevelMentions, "", ""),
+			pref(db.NotifyLevelMuted, "w", ""),
+		}, "w", "c", db.NotifyLevelMuted},
+		{"channel over workspace", []db.NotificationPreferenceModel{
+			pref(db.NotifyLevelMuted, "w", "c"),
+			pref(db.NotifyLevelMentions, "w", ""),
+		}, "w", "c", db.NotifyLevelMuted},
+		{"other workspace", []db.NotificationPreferenceModel{
+			pref(db.NotifyLevelMuted, "other", ""),
+		}, "w", "c", db.NotifyLevelAll},
+		{"other channel", []db.NotificationPreferenceModel{
+			pref(db.NotifyLevelMuted, "w", "other"),
+			pref(db.NotifyLevelMentions, "w", ""),
+		}, "w", "c", db.NotifyLevelMentions},
+		{"outside of a channel", []db.NotificationPreferenceModel{
+			pref(db.NotifyLevelMuted, "w", "c"),
+		}, "w", "", db.NotifyLevelAll},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveLevel(tt.prefs, tt.workspaceID, tt.channelID); got != tt.want {
+				t.Errorf("resolveLevel() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLevelAllows(t *testing.T) {
+	tests := []struct {
+		level   db.NotifyLevel
+		mention bool
+		want    bool
+	}{
+		{db.NotifyLevelAll, false, true},
+		{db.NotifyLevelMentions, false, false},
+		{db.NotifyLevelMentions, true, true},
+		{db.NotifyLevelMuted, true, false},
+	}
+	for _, tt := range tests {
+		if got := levelAllows(tt.level, tt.mention); got != tt.want {
+			t.Errorf("levelAllows(%s, %v) = %v, want %v", tt.level, tt.mention, got, tt.want)
+		}
+	}
+}
+
+func TestQuietAt(t *testing.T) {
+	quiet := func(start, end, timezone string, enabled bool) db.QuietHoursModel {
+		q := db.QuietHoursModel{}
+		q.Start, q.End, q.Timezone, q.Enabled = start, end, timezone, enabled
+		return q
+	}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 3, 5, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		quiet db.QuietHoursModel
+		now   time.Time
+		want  bool
+	}{
+		{"inside", quiet("09:00", "17:00", "UTC", true), at(12, 0), true},
+		{"at the start", quiet("09:00", "17:00", "UTC", true), at(9, 0), true},
+		{"at the end", quiet("09:00", "17:00", "UTC", true), at(17, 0), false},
+		{"outside", quiet("09:00", "17:00", "UTC", true), at(8, 59), false},
+		{"disabled", quiet("09:00", "17:00", "UTC", false), at(12, 0), false},
+		{"spans midnight, late", quiet("22:00", "07:00", "UTC", true), at(23, 30), true},
+		{"spans midnight, early", quiet("22:00", "07:00", "UTC", true), at(6, 59), true},
+		{"spans midnight, day", quiet("22:00", "07:00", "UTC", true), at(12, 0), false},
+		// 12:00 UTC is 21:00 in Tokyo
+		{"timezone", quiet("20:00", "23:00", "Asia/Tokyo", true), at(12, 0), true},
+		{"invalid timezone", quiet("00:00", "23:59", "Nowhere/City", true), at(12, 0), false},
+		{"invalid time", quiet("9am", "17:00", "UTC", true), at(12, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quietAt(tt.quiet, tt.now); got != tt.want {
+				t.Errorf("quietAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+const maxStatusTextLength = 100
+
+type PresenceService struct{}
+
+func NewPresenceService() *PresenceService {
+	return &PresenceService{}
+}
+
+// SetPresence stores the presence and custom status text a user shows in a
+// workspace. They are kept across connections.
+func (s *PresenceService) SetPresence(userID, workspaceID string, data types.PresenceD) (*db.UserWorkspaceModel, error) {
+	presence := db.Presence(data.Presence)
+	switch presence {
+	case db.PresenceActive, db.PresenceAway, db.PresenceDoNotDisturb:
+	default:
+		return nil, fmt.Errorf("invalid presence: %s", data.Presence)
+	}
+	if utf8.RuneCountInString(data.StatusText) > maxStatusTextLength {
+		return nil, fmt.Errorf("status text can't be longer than %d characters", maxStatusTextLength)
+	}
+
+	ctx := context.Background()
+	user, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userID),
+		db.UserWorkspace.WorkspaceID.Equals(workspaceID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("user is not part of the workspace: %v", err)
+	}
+
+	return prisma.Client.UserWorkspace.FindUnique(
+		db.UserWorkspace.ID.Equals(user.ID),
+	).Update(
+		db.UserWorkspace.Presence.Set(presence),
+		db.UserWorkspace.StatusText.Set(data.StatusText),
+	).Exec(ctx)
+}
+
+func (s *PresenceService) GetPresences(workspaceID string) ([]db.UserWorkspaceModel, error) {
+	users, err := prisma.Client.UserWorkspace.FindMany(
+		db.UserWorkspace.WorkspaceID.Equals(workspaceID),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+type ScheduledMessageService struct{}
+
+// claimTimeout is how long an entry may stay claimed before it's considered
+// abandoned by a dispatcher that crashed.
+const claimTimeout = 5 * time.Minute
+
+func NewScheduledMessageService() *ScheduledMessageService {
+	return &ScheduledMessageService{}
+}
+
+// ScheduleMessage stores a message to be posted to a channel at data.SendAt.
+func (s *ScheduledMessageService) ScheduleMessage(userID string, data types.ScheduledMessageD) (*db.ScheduledMessageModel, error) {
+	if data.Content == "" && data.AttachmentLink == "" {
+		return nil, errors.New("message is empty")
+	}
+	if !data.SendAt.After(time.Now()) {
+		return nil, errors.New("sendAt must be in the future")
+	}
+
+	channel, err := channelMember(userID, data.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	if channel.IsArchived {
+		return nil, ErrChannelArchived
+	}
+
+	optional := []db.ScheduledMessageSetParam{
+		db.ScheduledMessage.Kind.Set(db.ScheduledKindMessage),
+		db.ScheduledMessage.AttachmentLink.Set(data.AttachmentLink),
+		db.ScheduledMessage.AttachmentTitle.Set(data.AttachmentTitle),
+	}
+	if data.ParentID != "" {
+		parent, err := prisma.Client.Message.FindFirst(
+			db.Message.ID.Equals(data.ParentID),
+			db.Message.ChannelID.Equals(data.ChannelID),
+		).Exec(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("parent message not found: %v", err)
+		}
+		optional = append(optional, db.ScheduledMessage.ParentID.Set(parent.ID))
+	}
+
+	scheduled, err := prisma.Client.ScheduledMessage.CreateOne(
+		db.ScheduledMessage.SenderID.Set(userID),
+		db.ScheduledMessage.ChannelID.Set(data.ChannelID),
+		db.ScheduledMessage.Content.Set(data.Content),
+		db.ScheduledMessage.SendAt.Set(data.SendAt),
+		optional...,
+	).Exec(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to schedule message: %v", err)
+	}
+	return scheduled, nil
+}
+
+// CreateReminder schedules a private nudge about a message for its author.
+func (s *ScheduledMessageService) CreateReminder(userID string, data types.ReminderD) (*db.ScheduledMessageModel, error) {
+	if !data.RemindAt.After(time.Now()) {
+		return nil, errors.New("remindAt must be in the future")
+	}
+
+	message, err := prisma.Client.Message.FindUnique(
+		db.Message.ID.Equals(data.MessageID),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("message not found: %v", err)
+	}
+	if _, err := channelMember(userID, message.ChannelID); err != nil {
+		return nil, err
+	}
+
+	reminder, err := prisma.Client.ScheduledMessage.CreateOne(
+		db.ScheduledMessage.SenderID.Set(userID),
+		db.ScheduledMessage.ChannelID.Set(message.ChannelID),
+		db.ScheduledMessage.Content.Set(data.Note),
+		db.ScheduledMessage.SendAt.Set(data.RemindAt),
+		db.ScheduledMessage.Kind.Set(db.ScheduledKindReminder),
+		db.ScheduledMessage.MessageID.Set(message.ID),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reminder: %v", err)
+	}
+	return reminder, nil
+}
+
+// ListPending lists the user's pending scheduled messages and reminders, the
+// next due first. kind optionally narrows the list to MESSAGE or REMINDER.
+func (s *ScheduledMessageService) ListPending(userID, kind string) ([]db.ScheduledMessageModel, error) {
+	filters := []db.ScheduledMessageWhereParam{
+		db.ScheduledMessage.SenderID.Equals(userID),
+		db.ScheduledMessage.Status.Equals(db.ScheduledStatusPending),
+	}
+	switch kind {
+	case "":
+	case string(db.ScheduledKindMessage), string(db.ScheduledKindReminder):
+		filters = append(filters, db.ScheduledMessage.Kind.Equals(db.ScheduledKind(kind)))
+	default:
+		return nil, fmt.Errorf("invalid kind: %s", kind)
+	}
+
+	scheduled, err := prisma.Client.ScheduledMessage.FindMany(
+		filters...,
+	).OrderBy(
+		db.ScheduledMessage.SendAt.Order(db.SortOrderAsc),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return scheduled, nil
+}
+
+func (s *ScheduledMessageService) UpdateScheduled(userID, scheduledID string, data types.EditScheduledD) (*db.ScheduledMessageModel, error) {
+	var updates []db.ScheduledMessageSetParam
+	if data.Content != nil {
+		updates = append(updates, db.ScheduledMessage.Content.Set(*data.Content))
+	}
+	if data.SendAt != nil {
+		if !data.SendAt.After(time.Now()) {
+			return nil, errors.New("sendAt must be in the future")
+		}
+		updates = append(updates, db.ScheduledMessage.SendAt.Set(*data.SendAt))
+	}
+	if len(updates) == 0 {
+		return nil, errors.New("nothing to update")
+	}
+
+	return s.updatePending(userID, scheduledID, updates...)
+}
+
+func (s *ScheduledMessageService) CancelScheduled(userID, scheduledID string) (*db.ScheduledMessageModel, error) {
+	return s.updatePending(userID, scheduledID,
+		db.ScheduledMessage.Status.Set(db.ScheduledStatusCanceled),
+	)
+}
+
+// updatePending applies the updates only while the entry is still pending, so
+// an edit can't race with the dispatcher sending it.
+func (s *ScheduledMessageService) updatePending(userID, scheduledID string, updates ...db.ScheduledMessageSetParam) (*db.ScheduledMessageModel, error) {
+	ctx := context.Background()
+
+	res, err := prisma.Client.ScheduledMessage.FindMany(
+		db.ScheduledMessage.ID.Equals(scheduledID),
+		db.ScheduledMessage.SenderID.Equals(userID),
+		db.ScheduledMessage.Status.Equals(db.ScheduledStatusPending),
+	).Update(updates...).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if res.Count == 0 {
+		return nil, errors.New("scheduled message not found or already sent")
+	}
+
+	return prisma.Client.ScheduledMessage.FindUnique(
+		db.ScheduledMessage.ID.Equals(scheduledID),
+	).Exec(ctx)
+}
+
+// ClaimDue marks the pending entries due at now as sending and returns them.
+// Each entry is claimed on its own so that it's only ever dispatched once.
+func (s *ScheduledMessageService) ClaimDue(now time.Time) ([]db.ScheduledMessageModel, error) {
+	ctx := context.Background()
+
+	if err := s.releaseAbandoned(now); err != nil {
+		return nil, err
+	}
+
+	due, err := prisma.Client.ScheduledMessage.FindMany(
+		db.ScheduledMessage.Status.Equals(db.ScheduledStatusPending),
+		db.ScheduledMessage.SendAt.Lte(now),
+	).OrderBy(
+		db.ScheduledMessage.SendAt.Order(db.SortOrderAsc),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := make([]db.ScheduledMessageModel, 0, len(due))
+	for _, scheduled := range due {
+		res, err := prisma.Client.ScheduledMessage.FindMany(
+			db.ScheduledMessage.ID.Equals(scheduled.ID),
+			db.ScheduledMessage.Status.Equals(db.ScheduledStatusPending),
+		).Update(
+			db.ScheduledMessage.Status.Set(db.ScheduledStatusSending),
+			db.ScheduledMessage.ClaimedAt.Set(now),
+		).Exec(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if res.Count == 1 {
+			claimed = append(claimed, scheduled)
+		}
+	}
+	return claimed, nil
+}
+
+// releaseAbandoned fails the entries that were claimed but never marked,
+// because the dispatcher that claimed them stopped. They aren't retried since
+// the message may already have been posted.
+func (s *ScheduledMessageService) releaseAbandoned(now time.Time) error {
+	_, err := prisma.Client.ScheduledMessage.FindMany(
+		db.ScheduledMessage.Status.Equals(db.ScheduledStatusSending),
+		db.ScheduledMessage.ClaimedAt.Lt(now.Add(-claimTimeout)),
+	).Update(
+		db.ScheduledMessage.Status.Set(db.ScheduledStatusFailed),
+		db.ScheduledMessage.Failure.Set("dispatcher stopped while sending"),
+	).Exec(context.Background())
+	return err
+}
+
+func (s *ScheduledMessageService) MarkSent(scheduledID string) error {
+	_, err := prisma.Client.ScheduledMessage.FindUnique(
+		db.ScheduledMessage.ID.Equals(scheduledID),
+	).Update(
+		db.ScheduledMessage.Status.Set(db.ScheduledStatusSent),
+		db.ScheduledMessage.SentAt.Set(time.Now()),
+	).Exec(context.Background())
+	return err
+}
+
+func (s *ScheduledMessageService) MarkFailed(scheduledID string, reason error) error {
+	_, err := prisma.Client.ScheduledMessage.FindUnique(
+		db.ScheduledMessage.ID.Equals(scheduledID),
+	).Update(
+		db.ScheduledMessage.Status.Set(db.ScheduledStatusFailed),
+		db.ScheduledMessage.Failure.Set(reason.Error()),
+	).Exec(context.Background())
+	return err
+}
+
+// channelMember returns the channel if the user participates in it.
+func channelMember(userID, channelID string) (*db.ChannelModel, error) {
+	channel, _, err := channelParticipant(userID, channelID)
+	return channel, err
+}
+
+// channelParticipant returns the channel and the user's membership in its
+// workspace if the user participates in it.
+func channelParticipant(userID, channelID string) (*db.ChannelModel, *db.UserWorkspaceModel, error) {
+	ctx := context.Background()
+
+	channel, err := prisma.Client.Channel.FindUnique(
+		db.Channel.ID.Equals(channelID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("channel not found: %v", err)
+	}
+
+	user, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userID),
+		db.UserWorkspace.WorkspaceID.Equals(channel.WorkspaceID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user is not part of the workspace: %v", err)
+	}
+	for _, id := range channel.ParticipantsIDS {
+		if id == user.ID {
+			return channel, user, nil
+		}
+	}
+	return nil, nil, errors.New("user is not a participant of the channel")
+}
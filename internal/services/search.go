@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
+	"github.com/CollabTED/CollabTed-Backend/pkg/redis"
+	"github.com/CollabTED/CollabTed-Backend/pkg/search"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// bleve collects offset+limit hits in memory, deeper pages are refused
+	maxSearchOffset   = 1000
+	backfillBatchSize = 500
+
+	reindexChannel = "search:reindex"
+)
+
+type SearchService struct {
+	msgSrv *MessageService
+}
+
+func NewSearchService() *SearchService {
+	return &SearchService{
+		msgSrv: NewMessageService(),
+	}
+}
+
+// SearchResult is a page of matching messages, best match first.
+type SearchResult struct {
+	Messages []db.MessageModel `json:"messages"`
+	Total    uint64            `json:"total"`
+}
+
+// SearchMessages searches the messages of a workspace, only looking into the
+// channels the user participates in.
+func (s *SearchService) SearchMessages(userID, workspaceID string, params types.SearchD) (*SearchResult, error) {
+	ctx := context.Background()
+
+	if params.Query == "" {
+		return nil, errors.New("search query is required")
+	}
+	if params.Offset < 0 || params.Offset > maxSearchOffset {
+		return nil, fmt.Errorf("offset must be between 0 and %d", maxSearchOffset)
+	}
+
+	user, err := prisma.Client.UserWorkspace.FindFirst(
+		db.UserWorkspace.UserID.Equals(userID),
+		db.UserWorkspace.WorkspaceID.Equals(workspaceID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("user is not part of the workspace: %v", err)
+	}
+
+	channels, err := prisma.Client.Channel.FindMany(
+		db.Channel.WorkspaceID.Equals(workspaceID),
+		db.Channel.ParticipantsIDS.Has(user.ID),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var channelIDs []string
+	for _, channel := range channels {
+		if params.ChannelID != "" && channel.ID != params.ChannelID {
+			continue
+		}
+		channelIDs = append(channelIDs, channel.ID)
+	}
+
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	ids, total, err := search.Search(search.Query{
+		Text:          params.Query,
+		WorkspaceID:   workspaceID,
+		ChannelIDs:    channelIDs,
+		SenderID:      params.SenderID,
+		After:         params.After,
+		Before:        params.Before,
+		HasAttachment: params.HasAttachment,
+		IsPinned:      params.IsPinned,
+		Size:          limit,
+		Offset:        params.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := prisma.Client.Message.FindMany(
+		db.Message.ID.In(ids),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the ranking of the index
+	byID := make(map[string]db.MessageModel, len(messages))
+	for _, message := range messages {
+		byID[message.ID] = message
+	}
+	ranked := make([]db.MessageModel, 0, len(ids))
+	for _, id := range ids {
+		if message, ok := byID[id]; ok {
+			ranked = append(ranked, message)
+		}
+	}
+
+	return &SearchResult{
+		Messages: ranked,
+		Total:    total,
+	}, nil
+}
+
+// Backfill indexes every stored message. It runs when the search index is
+// created, or at startup with SEARCH_REINDEX set.
+func (s *SearchService) Backfill() error {
+	indexed, err := s.index()
+	if err != nil {
+		return err
+	}
+	logger.LogInfo().Msgf("Indexed %d messages", indexed)
+	return nil
+}
+
+// ReindexWorkspace indexes the messages of a workspace again.
+func (s *SearchService) ReindexWorkspace(workspaceID string) error {
+	channels, err := prisma.Client.Channel.FindMany(
+		db.Channel.WorkspaceID.Equals(workspaceID),
+	).Exec(context.Background())
+	if err != nil {
+		return err
+	}
+	channelIDs := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		channelIDs = append(channelIDs, channel.ID)
+	}
+
+	indexed, err := s.index(db.Message.ChannelID.In(channelIDs))
+	if err != nil {
+		return err
+	}
+	logger.LogInfo().Msgf("Indexed %d messages of workspace %s", indexed, workspaceID)
+	return nil
+}
+
+// RequestReindex asks every replica, each holding its own index on local
+// disk, to reindex the workspace.
+func (s *SearchService) RequestReindex(workspaceID string) error {
+	return redis.GetClient().Publish(context.Background(), reindexChannel, workspaceID).Err()
+}
+
+// WatchReindex reindexes the workspaces requested by RequestReindex on this
+// replica.
+func (s *SearchService) WatchReindex() {
+	pubsub := redis.GetClient().Subscribe(context.Background(), reindexChannel)
+	defer pubsub.Close()
+
+	for msg := range pubsub.Channel() {
+		if err := s.ReindexWorkspace(msg.Payload); err != nil {
+			logger.LogError().Msgf("Failed to reindex workspace %s: %v", msg.Payload, err)
+		}
+	}
+}
+
+// index indexes the messages matching the filters in batches and returns how
+// many it indexed.
+func (s *SearchService) index(filters ...db.MessageWhereParam) (int, error) {
+	ctx := context.Background()
+	indexed := 0
+	cursor := ""
+	for {
+		query := prisma.Client.Message.FindMany(filters...).OrderBy(
+			db.Message.ID.Order(db.SortOrderAsc),
+		)
+		skip := 0
+		if cursor != "" {
+			query = query.Cursor(db.Message.ID.Cursor(cursor))
+			skip = 1
+		}
+		messages, err := query.Skip(skip).Take(backfillBatchSize).Exec(ctx)
+		if err != nil {
+			return indexed, err
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		for i := range messages {
+			if messages[i].IsDeleted {
+				continue
+			}
+			s.msgSrv.indexMessage(&messages[i])
+		}
+		indexed += len(messages)
+		cursor = messages[len(messages)-1].ID
+	}
+	return indexed, nil
+}
@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/CollabTED/CollabTed-Backend/config"
 	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
+	"github.com/CollabTED/CollabTed-Backend/pkg/mail"
 	"github.com/CollabTED/CollabTed-Backend/pkg/types"
 	"github.com/CollabTED/CollabTed-Backend/prisma"
 	"github.com/CollabTED/CollabTed-Backend/prisma/db"
@@ -56,6 +59,8 @@ func (s *TaskService) CreateTask(data types.TaskD) (*db.TaskModel, error) {
 			return nil, fmt.Errorf("failed to add assignee with ID %s to the task: %v", assigneeID, err)
 		}
 	}
+	go notifyAssignees(result.ID, data.AssigneesIDs)
+	go emitTaskWebhook(WebhookTaskCreated, result.ProjectID, result)
 
 	return result, nil
 }
@@ -151,6 +156,12 @@ func (s *TaskService) AddAssignees(workspaceID, taskID string, userID []string)
 		}
 	}
 
+	assigned := make([]string, len(users))
+	for i, user := range users {
+		assigned[i] = user.ID
+	}
+	go notifyAssignees(taskID, assigned)
+
 	return users, nil
 }
 
@@ -229,6 +240,12 @@ func (s *TaskService) ChangeTaskStatus(taskId, statusId string) (*db.TaskModel,
 	if err != nil {
 		return nil, err
 	}
+	previous, err := prisma.Client.Task.FindUnique(
+		db.Task.ID.Equals(taskId),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
 	task, err := prisma.Client.Task.FindUnique(
 		db.Task.ID.Equals(taskId),
 	).Update(
@@ -239,10 +256,30 @@ func (s *TaskService) ChangeTaskStatus(taskId, statusId string) (*db.TaskModel,
 	if err != nil {
 		return nil, err
 	}
+	if previous.StatusID != task.StatusID {
+		go emitTaskWebhook(WebhookTaskStatusChanged, task.ProjectID, map[string]any{
+			"task":             task,
+			"previousStatusId": previous.StatusID,
+			"status":           status,
+		})
+	}
 	return task, nil
 
 }
 
+// emitTaskWebhook emits a task event to the webhooks of the workspace of the
+// task's project.
+func emitTaskWebhook(event, projectID string, data any) {
+	project, err := prisma.Client.Project.FindUnique(
+		db.Project.ID.Equals(projectID),
+	).Exec(context.Background())
+	if err != nil {
+		logger.LogError().Msgf("Failed to load project %s for webhooks: %v", projectID, err)
+		return
+	}
+	emitWebhook(project.WorkspaceID, event, data)
+}
+
 // AssignUserToTask assigns a single user to a task using the userWorkspaceID.
 func (s *TaskService) AssignUserToTask(taskID, userWorkspaceID string) (*db.TaskModel, error) {
 	ctx := context.Background()
@@ -256,10 +293,68 @@ func (s *TaskService) AssignUserToTask(taskID, userWorkspaceID string) (*db.Task
 	if err != nil {
 		return nil, fmt.Errorf("failed to assign userWorkspaceID %s to task: %v", userWorkspaceID, err)
 	}
+	go notifyAssignees(task.ID, []string{userWorkspaceID})
 
 	return task, nil
 }
 
+// notifyAssignees emails the users newly assigned to a task, unless they
+// muted their notifications, are in their quiet hours or in do not disturb.
+// The assignment still shows on the board.
+func notifyAssignees(taskID string, userWorkspaceIDs []string) {
+	ctx := context.Background()
+	if len(userWorkspaceIDs) == 0 {
+		return
+	}
+
+	task, err := prisma.Client.Task.FindUnique(
+		db.Task.ID.Equals(taskID),
+	).With(db.Task.Project.Fetch()).Exec(ctx)
+	if err != nil {
+		logger.LogError().Msgf("Failed to load task %s for its assignees: %v", taskID, err)
+		return
+	}
+
+	// bots have no mailbox
+	assignees, err := prisma.Client.UserWorkspace.FindMany(
+		db.UserWorkspace.ID.In(userWorkspaceIDs),
+		db.UserWorkspace.User.Where(
+			db.User.IsBot.Equals(false),
+		),
+	).With(
+		db.UserWorkspace.User.Fetch(),
+		db.UserWorkspace.Workspace.Fetch(),
+	).Exec(ctx)
+	if err != nil {
+		logger.LogError().Msgf("Failed to load the assignees of task %s: %v", taskID, err)
+		return
+	}
+
+	ids := make([]string, 0, len(assignees))
+	for _, assignee := range assignees {
+		ids = append(ids, assignee.UserID)
+	}
+	policy := loadPolicy(ids, task.Project().WorkspaceID, "")
+	now := time.Now()
+	for _, assignee := range assignees {
+		if decision := policy.Decide(assignee.UserID, true, now); !decision.Notify || decision.Quiet {
+			continue
+		}
+		user := assignee.User()
+		err := mail.Send(mail.TemplateTaskAssignment, []string{user.Email}, mail.TaskAssignmentData{
+			UserName:  user.Name,
+			Title:     task.Title,
+			Project:   task.Project().Title,
+			Workspace: assignee.Workspace().WorkspaceName,
+			DueDate:   task.DueDate,
+			Link:      config.HOST_URL,
+		})
+		if err != nil {
+			logger.LogError().Msgf("Failed to send task assignment to %s: %v", user.ID, err)
+		}
+	}
+}
+
 func (s *ProjectService) IsUserMemberOfProject(userId, workspaceId, projectId string) (bool, error) {
 	// Check if the user is part of the workspace and project
 	fmt.Println(userId, workspaceId, projectId)
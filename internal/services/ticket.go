@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/redis"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	r "github.com/redis/go-redis/v9"
+)
+
+// TicketTTL is how long a connection ticket can be redeemed for.
+const TicketTTL = 30 * time.Second
+
+// TicketService issues one-time tickets that authenticate a WebSocket or SSE
+// connection, for clients that can't send a cookie or header with it.
+type TicketService struct{}
+
+func NewTicketService() *TicketService {
+	return &TicketService{}
+}
+
+func ticketKey(ticket string) string {
+	return "ticket:" + ticket
+}
+
+func (s *TicketService) IssueTicket(claims *types.Claims) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ticket := hex.EncodeToString(b)
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	err = redis.GetClient().Set(context.Background(), ticketKey(ticket), payload, TicketTTL).Err()
+	if err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+// RedeemTicket returns the claims a ticket was issued for. A ticket can only
+// be redeemed once.
+func (s *TicketService) RedeemTicket(ticket string) (*types.Claims, error) {
+	payload, err := redis.GetClient().GetDel(context.Background(), ticketKey(ticket)).Bytes()
+	if errors.Is(err, r.Nil) {
+		return nil, errors.New("invalid or expired ticket")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var claims types.Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
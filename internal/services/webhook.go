@@ -0,0 +1,535 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+// Events that can be delivered to a webhook, which may also subscribe to
+// "*" for all of them.
+const (
+	WebhookMessageCreated    = "message.created"
+	WebhookMessageDeleted    = "message.deleted"
+	WebhookTaskCreated       = "task.created"
+	WebhookTaskStatusChanged = "task.status_changed"
+	WebhookEventCreated      = "event.created"
+	WebhookMemberJoined      = "member.joined"
+	WebhookMemberRemoved     = "member.removed"
+	webhookAllEvents         = "*"
+)
+
+const (
+	webhookDispatchInterval   = 5 * time.Second
+	webhookMaxAttempts        = 8
+	webhookRetryBackoff       = 30 * time.Second
+	webhookTimeout            = 10 * time.Second
+	webhookDeliveriesPageSize = 20
+	// webhookClaimTimeout is how long a delivery may stay claimed before
+	// it's considered abandoned, well above webhookTimeout
+	webhookClaimTimeout = 2 * time.Minute
+	// webhookConcurrency bounds the deliveries sent at once by a replica
+	webhookConcurrency = 8
+)
+
+var webhookEvents = map[string]struct{}{
+	WebhookMessageCreated:    {},
+	WebhookMessageDeleted:    {},
+	WebhookTaskCreated:       {},
+	WebhookTaskStatusChanged: {},
+	WebhookEventCreated:      {},
+	WebhookMemberJoined:      {},
+	WebhookMemberRemoved:     {},
+}
+
+// webhookClient only connects to public addresses, whatever the URL resolves
+// to at the time, and doesn't follow redirects, which count as failures.
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: webhookTimeout,
+			Control: dialPublicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// nonPublicRanges are the special purpose ranges the net.IP predicates don't
+// cover.
+var nonPublicRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// WebhookService lets workspaces subscribe URLs to their events. Every event
+// is stored as a delivery per subscribed webhook and POSTed by Run, retrying
+// with an exponential backoff until the endpoint answers with a 2xx.
+//
+// Payloads are signed with the webhook's secret: the X-CollabTED-Signature
+// header is "sha256=" followed by the hex HMAC-SHA256 of the
+// X-CollabTED-Timestamp header, a dot and the body.
+type WebhookService struct{}
+
+func NewWebhookService() *WebhookService {
+	return &WebhookService{}
+}
+
+type webhookPayload struct {
+	Event       string    `json:"event"`
+	WorkspaceID string    `json:"workspaceId"`
+	OccurredAt  time.Time `json:"occurredAt"`
+	Data        any       `json:"data"`
+}
+
+// CreateWebhook registers an endpoint for the workspace. The secret is only
+// ever returned here.
+func (s *WebhookService) CreateWebhook(userID, workspaceID string, data types.WebhookD) (*db.WebhookModel, error) {
+	if err := validateWebhook(data); err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	optional := []db.WebhookSetParam{
+		db.Webhook.Events.Set(data.Events),
+	}
+	if data.Active != nil {
+		optional = append(optional, db.Webhook.Active.Set(*data.Active))
+	}
+	webhook, err := prisma.Client.Webhook.CreateOne(
+		db.Webhook.WorkspaceID.Set(workspaceID),
+		db.Webhook.CreatorID.Set(userID),
+		db.Webhook.URL.Set(data.URL),
+		db.Webhook.Secret.Set(hex.EncodeToString(secret)),
+		optional...,
+	).Exec(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %v", err)
+	}
+	return webhook, nil
+}
+
+func (s *WebhookService) ListWebhooks(workspaceID string) ([]db.WebhookModel, error) {
+	webhooks, err := prisma.Client.Webhook.FindMany(
+		db.Webhook.WorkspaceID.Equals(workspaceID),
+	).OrderBy(
+		db.Webhook.CreatedAt.Order(db.SortOrderDesc),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+func (s *WebhookService) UpdateWebhook(workspaceID, webhookID string, data types.WebhookD) (*db.WebhookModel, error) {
+	if err := validateWebhook(data); err != nil {
+		return nil, err
+	}
+	if _, err := s.getWebhook(workspaceID, webhookID); err != nil {
+		return nil, err
+	}
+
+	updates := []db.WebhookSetParam{
+		db.Webhook.URL.Set(data.URL),
+		db.Webhook.Events.Set(data.Events),
+	}
+	if data.Active != nil {
+		updates = append(updates, db.Webhook.Active.Set(*data.Active))
+	}
+	webhook, err := prisma.Client.Webhook.FindUnique(
+		db.Webhook.ID.Equals(webhookID),
+	).Update(updates...).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+func (s *WebhookService) DeleteWebhook(workspaceID, webhookID string) error {
+	ctx := context.Background()
+	if _, err := s.getWebhook(workspaceID, webhookID); err != nil {
+		return err
+	}
+
+	_, err := prisma.Client.WebhookDelivery.FindMany(
+		db.WebhookDelivery.WebhookID.Equals(webhookID),
+	).Delete().Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = prisma.Client.Webhook.FindUnique(
+		db.Webhook.ID.Equals(webhookID),
+	).Delete().Exec(ctx)
+	return err
+}
+
+// ListDeliveries returns a page of the webhook's delivery log, newest first.
+func (s *WebhookService) ListDeliveries(workspaceID, webhookID string, page int) ([]db.WebhookDeliveryModel, error) {
+	if _, err := s.getWebhook(workspaceID, webhookID); err != nil {
+		return nil, err
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	return prisma.Client.WebhookDelivery.FindMany(
+		db.WebhookDelivery.WebhookID.Equals(webhookID),
+	).OrderBy(
+		db.WebhookDelivery.CreatedAt.Order(db.SortOrderDesc),
+	).Skip((page - 1) * webhookDeliveriesPageSize).Take(webhookDeliveriesPageSize).Exec(context.Background())
+}
+
+// Redeliver queues the payload of a past delivery again, as a new delivery.
+func (s *WebhookService) Redeliver(workspaceID, webhookID, deliveryID string) (*db.WebhookDeliveryModel, error) {
+	if _, err := s.getWebhook(workspaceID, webhookID); err != nil {
+		return nil, err
+	}
+
+	delivery, err := prisma.Client.WebhookDelivery.FindFirst(
+		db.WebhookDelivery.ID.Equals(deliveryID),
+		db.WebhookDelivery.WebhookID.Equals(webhookID),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, errors.New("delivery not found")
+	}
+	return createDelivery(webhookID, delivery.Event, delivery.Payload)
+}
+
+func (s *WebhookService) getWebhook(workspaceID, webhookID string) (*db.WebhookModel, error) {
+	webhook, err := prisma.Client.Webhook.FindFirst(
+		db.Webhook.ID.Equals(webhookID),
+		db.Webhook.WorkspaceID.Equals(workspaceID),
+	).Exec(context.Background())
+	if err != nil {
+		return nil, errors.New("webhook not found")
+	}
+	return webhook, nil
+}
+
+func validateWebhook(data types.WebhookD) error {
+	u, err := url.Parse(data.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if err := checkPublicHost(u.Hostname()); err != nil {
+		return err
+	}
+	if len(data.Events) == 0 {
+		return errors.New("at least one event is required")
+	}
+	for _, event := range data.Events {
+		if _, ok := webhookEvents[event]; !ok && event != webhookAllEvents {
+			return fmt.Errorf("unknown event: %s", event)
+		}
+	}
+	return nil
+}
+
+// checkPublicHost resolves the host and fails if any of its addresses isn't
+// public. The dialer checks again on every connection, as the host may
+// resolve differently later.
+func checkPublicHost(host string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("url host can't be resolved: %s", host)
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return errors.New("url must not point to a loopback, private or link-local address")
+		}
+	}
+	return nil
+}
+
+// dialPublicOnly refuses connections to addresses that aren't public, such
+// as the loopback, private networks or the cloud metadata endpoint.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range nonPublicRanges {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// emitWebhook records a delivery of the event for every active webhook of
+// the workspace subscribed to it. It runs in the background of the action
+// that caused the event, which never fails because of it.
+func emitWebhook(workspaceID, event string, data any) {
+	webhooks, err := prisma.Client.Webhook.FindMany(
+		db.Webhook.WorkspaceID.Equals(workspaceID),
+		db.Webhook.Active.Equals(true),
+	).Exec(context.Background())
+	if err != nil {
+		logger.LogError().Msgf("Failed to load the webhooks of %s: %v", workspaceID, err)
+		return
+	}
+
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !subscribed(webhook.Events, event) {
+			continue
+		}
+		if payload == nil {
+			payload, err = json.Marshal(webhookPayload{
+				Event:       event,
+				WorkspaceID: workspaceID,
+				OccurredAt:  time.Now(),
+				Data:        data,
+			})
+			if err != nil {
+				logger.LogError().Msgf("Failed to marshal %s webhook: %v", event, err)
+				return
+			}
+		}
+		if _, err := createDelivery(webhook.ID, event, payload); err != nil {
+			logger.LogError().Msgf("Failed to queue %s for webhook %s: %v", event, webhook.ID, err)
+		}
+	}
+}
+
+func subscribed(events []string, event string) bool {
+	for _, e := range events {
+		if e == event || e == webhookAllEvents {
+			return true
+		}
+	}
+	return false
+}
+
+func createDelivery(webhookID, event string, payload []byte) (*db.WebhookDeliveryModel, error) {
+	return prisma.Client.WebhookDelivery.CreateOne(
+		db.WebhookDelivery.WebhookID.Set(webhookID),
+		db.WebhookDelivery.Event.Set(event),
+		db.WebhookDelivery.Payload.Set(payload),
+		db.WebhookDelivery.NextAttemptAt.Set(time.Now()),
+	).Exec(context.Background())
+}
+
+// Run sends the deliveries as they come due.
+func (s *WebhookService) Run() {
+	ticker := time.NewTicker(webhookDispatchInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if err := s.dispatchDue(now); err != nil {
+			logger.LogError().Msgf("Failed to dispatch webhooks: %v", err)
+		}
+	}
+}
+
+// dispatchDue claims each due delivery before sending it, so that only one
+// replica does, and sends up to webhookConcurrency of them at once.
+func (s *WebhookService) dispatchDue(now time.Time) error {
+	ctx := context.Background()
+
+	if err := s.releaseAbandoned(now); err != nil {
+		return err
+	}
+
+	due, err := prisma.Client.WebhookDelivery.FindMany(
+		db.WebhookDelivery.Status.Equals(db.DeliveryStatusPending),
+		db.WebhookDelivery.NextAttemptAt.Lte(now),
+	).OrderBy(
+		db.WebhookDelivery.NextAttemptAt.Order(db.SortOrderAsc),
+	).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	slots := make(chan struct{}, webhookConcurrency)
+	for _, delivery := range due {
+		res, err := prisma.Client.WebhookDelivery.FindMany(
+			db.WebhookDelivery.ID.Equals(delivery.ID),
+			db.WebhookDelivery.Status.Equals(db.DeliveryStatusPending),
+		).Update(
+			db.WebhookDelivery.Status.Set(db.DeliveryStatusDelivering),
+			db.WebhookDelivery.ClaimedAt.Set(now),
+		).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if res.Count == 0 {
+			continue
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(delivery db.WebhookDeliveryModel) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := s.attempt(delivery); err != nil {
+				logger.LogError().Msgf("Failed to record webhook delivery %s: %v", delivery.ID, err)
+			}
+		}(delivery)
+	}
+	return nil
+}
+
+// releaseAbandoned puts back the deliveries that were claimed but never
+// recorded, because the dispatcher that claimed them stopped. Unlike
+// scheduled messages they are retried, receivers tell a repeated delivery
+// by its X-CollabTED-Delivery header.
+func (s *WebhookService) releaseAbandoned(now time.Time) error {
+	_, err := prisma.Client.WebhookDelivery.FindMany(
+		db.WebhookDelivery.Status.Equals(db.DeliveryStatusDelivering),
+		db.WebhookDelivery.ClaimedAt.Lt(now.Add(-webhookClaimTimeout)),
+	).Update(
+		db.WebhookDelivery.Status.Set(db.DeliveryStatusPending),
+		db.WebhookDelivery.NextAttemptAt.Set(now),
+	).Exec(context.Background())
+	return err
+}
+
+// attempt POSTs the delivery once and records the outcome, scheduling the
+// next attempt on failure.
+func (s *WebhookService) attempt(delivery db.WebhookDeliveryModel) error {
+	ctx := context.Background()
+	attempts := delivery.Attempts + 1
+
+	webhook, err := prisma.Client.Webhook.FindUnique(
+		db.Webhook.ID.Equals(delivery.WebhookID),
+	).Exec(ctx)
+	if err != nil {
+		_, err = prisma.Client.WebhookDelivery.FindUnique(
+			db.WebhookDelivery.ID.Equals(delivery.ID),
+		).Update(
+			db.WebhookDelivery.Status.Set(db.DeliveryStatusFailed),
+			db.WebhookDelivery.LastError.Set("webhook was deleted"),
+		).Exec(ctx)
+		return err
+	}
+
+	status, sendErr := sendWebhook(webhook, delivery)
+
+	updates := []db.WebhookDeliverySetParam{
+		db.WebhookDelivery.Attempts.Set(attempts),
+	}
+	if status != 0 {
+		updates = append(updates, db.WebhookDelivery.ResponseStatus.Set(status))
+	}
+	switch {
+	case sendErr == nil:
+		updates = append(updates,
+			db.WebhookDelivery.Status.Set(db.DeliveryStatusSucceeded),
+			db.WebhookDelivery.DeliveredAt.Set(time.Now()),
+		)
+	case attempts >= webhookMaxAttempts:
+		updates = append(updates,
+			db.WebhookDelivery.Status.Set(db.DeliveryStatusFailed),
+			db.WebhookDelivery.LastError.Set(sendErr.Error()),
+		)
+	default:
+		backoff := webhookRetryBackoff << (attempts - 1)
+		updates = append(updates,
+			db.WebhookDelivery.Status.Set(db.DeliveryStatusPending),
+			db.WebhookDelivery.LastError.Set(sendErr.Error()),
+			db.WebhookDelivery.NextAttemptAt.Set(time.Now().Add(backoff)),
+		)
+	}
+
+	_, err = prisma.Client.WebhookDelivery.FindUnique(
+		db.WebhookDelivery.ID.Equals(delivery.ID),
+	).Update(updates...).Exec(ctx)
+	return err
+}
+
+// sendWebhook POSTs the signed payload and returns the response status, with an
+// error unless it is a 2xx.
+func sendWebhook(webhook *db.WebhookModel, delivery db.WebhookDeliveryModel) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CollabTED-Webhooks")
+	req.Header.Set("X-CollabTED-Event", delivery.Event)
+	req.Header.Set("X-CollabTED-Delivery", delivery.ID)
+	req.Header.Set("X-CollabTED-Timestamp", timestamp)
+	req.Header.Set("X-CollabTED-Signature", signWebhook(webhook.Secret, timestamp, delivery.Payload))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// the body isn't kept, it's shown to workspace admins in the delivery log
+	// and may be anything the endpoint answers
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("endpoint answered %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// signWebhook returns the X-CollabTED-Signature header of a payload.
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
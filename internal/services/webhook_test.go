@@ -0,0 +1,63 @@
+package services
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSignWebhook(t *testing.T) {
+	got := signWebhook("secret", "1700000000", []byte(`{"event":"message.created"}`))
+	want := "sha256=39e442eaff327dcb8b1928c5e20f0eb2ae9df15a96a515e319999417b326c228"
+	if got != want {
+		t.Errorf("signWebhook() = %s, want %s", got, want)
+	}
+
+	if other := signWebhook("secret", "1700000001", []byte(`{"event":"message.created"}`)); other == got {
+		t.Error("the signature doesn't cover the timestamp")
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"::ffff:169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestSubscribed(t *testing.T) {
+	tests := []struct {
+		events []string
+		event  string
+		want   bool
+	}{
+		{[]string{WebhookMessageCreated}, WebhookMessageCreated, true},
+		{[]string{WebhookTaskCreated}, WebhookMessageCreated, false},
+		{[]string{webhookAllEvents}, WebhookMemberJoined, true},
+		{nil, WebhookMessageCreated, false},
+	}
+	for _, tt := range tests {
+		if got := subscribed(tt.events, tt.event); got != tt.want {
+			t.Errorf("subscribed(%v, %s) = %v, want %v", tt.events, tt.event, got, tt.want)
+		}
+	}
+}
@@ -237,6 +237,12 @@ func (s *WorkspaceService) AcceptInvitation(userID, token string) (string, error
 	if err != nil {
 		return "", fmt.Errorf("failed to update invitation status: %v", err)
 	}
+	go emitWebhook(invitation.WorkspaceID, WebhookMemberJoined, map[string]any{
+		"userId":          userID,
+		"userWorkspaceId": userWorkspace.ID,
+		"name":            uniqueName,
+		"role":            userWorkspace.Role,
+	})
 
 	return invitation.WorkspaceID, nil
 }
@@ -375,6 +381,11 @@ func (s *WorkspaceService) KickUser(workspaceId string, userId string) (*db.Work
 	if err != nil {
 		return nil, err
 	}
+	go emitWebhook(workspaceId, WebhookMemberRemoved, map[string]any{
+		"userId":          userId,
+		"userWorkspaceId": userwrk.ID,
+		"role":            userwrk.Role,
+	})
 	return userwrk.Workspace(), nil
 }
 
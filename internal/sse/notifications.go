@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
 	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
 	"github.com/CollabTED/CollabTed-Backend/pkg/redis"
 	"github.com/CollabTED/CollabTed-Backend/pkg/types"
@@ -13,13 +15,21 @@ import (
 	r "github.com/redis/go-redis/v9"
 )
 
+// alwaysNotify is used for the notifications preferences don't apply to,
+// such as workspace membership changes.
+var alwaysNotify = services.NotifyDecision{Notify: true}
+
 type Notifier struct {
 	client *r.Client
+	inbox  *services.NotificationService
+	prefs  *services.NotificationPreferenceService
 }
 
 func NewNotifier() *Notifier {
 	return &Notifier{
 		client: redis.GetClient(),
+		inbox:  services.NewNotificationService(),
+		prefs:  services.NewNotificationPreferenceService(),
 	}
 }
 
@@ -32,7 +42,8 @@ func (n *Notifier) NotificationHandler(c echo.Context) error {
 	c.Response().Header().Del("Content-Length")
 	c.Response().Flush()
 
-	id := c.QueryParam("userID")
+	// the user comes from the verified token, never from the query string
+	id := c.Get("user").(*types.Claims).ID
 
 	// Ensure the Content-Length is not set, as SSE is a streaming response
 	c.Response().Header().Del("Content-Length")
@@ -67,33 +78,29 @@ func (n *Notifier) NotifyCallUser(userID, roomID, callerID string) error {
 		CallerID: callerID,
 		RoomID:   roomID,
 	}
+	// calls aren't tied to a channel, only a muted default or quiet hours
+	// hold them back
+	decision := n.prefs.Decide(userID, "", true, time.Now())
+	return n.publish(userID, call.Type, call, decision)
+}
 
-	b, err := json.Marshal(call)
-	if err != nil {
-		log.Printf("Failed to marshal call: %v", err)
-		return err
-	}
-	err = n.client.Publish(context.Background(), "notifs:"+userID, b).Err()
-	if err != nil {
-		log.Printf("Failed to publish notification: %v", err)
-		return err
-	}
-	return nil
+// NotifyPing notifies the user of a message in a channel as decided by the
+// caller, which loads the preferences of all the recipients at once.
+func (n *Notifier) NotifyPing(userID string, notif types.PingNotification, decision services.NotifyDecision) error {
+	return n.publish(userID, notif.Type, notif, decision)
 }
 
-func (n *Notifier) NotifyPing(userID string, notif types.PingNotification) error {
-	b, err := json.Marshal(notif)
-	if err != nil {
-		log.Printf("Failed to marshal call :%v", err)
-		return err
-	}
-	fmt.Println(notif, "notification")
-	err = n.client.Publish(context.Background(), "notifs:"+userID, b).Err()
-	if err != nil {
-		log.Printf("Failed to publish notification: %v", err)
-		return err
-	}
-	return nil
+// NotifyMention notifies the user they were mentioned, as decided by the
+// caller.
+func (n *Notifier) NotifyMention(userID string, notif types.MentionNotification, decision services.NotifyDecision) error {
+	notif.Type = types.MENTION_NOTIFICATION
+	return n.publish(userID, notif.Type, notif, decision)
+}
+
+func (n *Notifier) NotifyReminder(userID string, notif types.ReminderNotification) error {
+	notif.Type = types.REMINDER_NOTIFICATION
+	// the user asked for the reminder, it's always delivered
+	return n.publish(userID, notif.Type, notif, alwaysNotify)
 }
 
 func (n *Notifier) NotifyKickUser(userID, workspaceID string) error {
@@ -101,20 +108,8 @@ func (n *Notifier) NotifyKickUser(userID, workspaceID string) error {
 		Type:        types.KICK_NOTIFICATION,
 		WorkspaceID: workspaceID,
 	}
-
-	b, err := json.Marshal(notif)
-	if err != nil {
-		log.Printf("Failed to marshal call: %v", err)
-		return err
-	}
-
-	logger.LogInfo().Msgf("Publishing kick notification: %s", string(b))
-	err = n.client.Publish(context.Background(), "notifs:"+userID, b).Err()
-	if err != nil {
-		log.Printf("Failed to publish notification: %v", err)
-		return err
-	}
-	return nil
+	logger.LogInfo().Msgf("Publishing kick notification for %s in %s", userID, workspaceID)
+	return n.publish(userID, notif.Type, notif, alwaysNotify)
 }
 
 func (n *Notifier) NotifyJoinUser(userID, workspaceID string) error {
@@ -122,14 +117,37 @@ func (n *Notifier) NotifyJoinUser(userID, workspaceID string) error {
 		Type:        types.JOIN_NOTIFICATION,
 		WorkspaceID: workspaceID,
 	}
+	logger.LogInfo().Msgf("Publishing join notification for %s in %s", userID, workspaceID)
+	return n.publish(userID, notif.Type, notif, alwaysNotify)
+}
+
+// publish stores the notification in the user's inbox, then sends it to the
+// user's open streams with the "notificationId" of the stored copy so clients
+// can mark it read. A notification that can't be stored is still published.
+// The decision from the user's preferences may drop the notification, or
+// keep it in the inbox without pushing it.
+func (n *Notifier) publish(userID string, notifType types.NotifType, notif any, decision services.NotifyDecision) error {
+	if !decision.Notify {
+		return nil
+	}
 
 	b, err := json.Marshal(notif)
 	if err != nil {
-		log.Printf("Failed to marshal call: %v", err)
+		log.Printf("Failed to marshal %s notification: %v", notifType, err)
+		return err
+	}
+
+	stored, err := n.inbox.CreateNotification(userID, string(notifType), b)
+	if err != nil {
+		log.Printf("Failed to store notification: %v", err)
+	} else if b, err = withNotificationID(b, stored.ID); err != nil {
+		log.Printf("Failed to marshal %s notification: %v", notifType, err)
 		return err
 	}
+	if decision.Quiet {
+		return nil
+	}
 
-	logger.LogInfo().Msgf("Publishing join notification: %s", string(b))
 	err = n.client.Publish(context.Background(), "notifs:"+userID, b).Err()
 	if err != nil {
 		log.Printf("Failed to publish notification: %v", err)
@@ -137,3 +155,16 @@ func (n *Notifier) NotifyJoinUser(userID, workspaceID string) error {
 	}
 	return nil
 }
+
+func withNotificationID(payload []byte, id string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+	fields["notificationId"] = encoded
+	return json.Marshal(fields)
+}
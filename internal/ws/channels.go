@@ -4,14 +4,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
-	"github.com/CollabTED/CollabTed-Backend/config"
 	"github.com/CollabTED/CollabTed-Backend/internal/services"
 	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
 	"github.com/CollabTED/CollabTed-Backend/pkg/types"
-	"github.com/CollabTED/CollabTed-Backend/prisma/db"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 )
@@ -32,30 +30,20 @@ func (ws WsChatHandler) Connections(c echo.Context) error {
 		CheckOrigin:     func(r *http.Request) bool { return true },
 	}
 
-	// Authentication handling
-	cookie, err := c.Cookie("jwt")
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
-	}
-	if cookie.Value == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "token is required")
-	}
+	// authenticated by the stream middleware
+	claims := c.Get("user").(*types.Claims)
 
-	token, err := jwt.ParseWithClaims(cookie.Value, &types.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.JWT_SECRET), nil
-	})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	// only members see who is online in a workspace
+	workspaceID := c.QueryParam("workspaceID")
+	if _, err := wrkSrv.GetUserInWorkspace(claims.ID, workspaceID); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "you are not a member of this workspace")
 	}
 
-	claims := token.Claims.(*types.Claims)
 	conn, err := upgrader.Upgrade(c.Response().Writer, c.Request(), nil)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	// Get workspace ID and create user
-	workspaceID := c.QueryParam("workspaceID")
 	user := &User{
 		UserID:      claims.ID,
 		WorkspaceID: workspaceID,
@@ -123,21 +111,19 @@ func (ws WsChatHandler) Chat(c echo.Context) error {
 		CheckOrigin:     func(r *http.Request) bool { return true },
 	}
 
-	cookie, err := c.Cookie("jwt")
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
-	}
-	if cookie.Value == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "token is required")
-	}
+	// authenticated by the stream middleware
+	claims := c.Get("user").(*types.Claims)
 
-	token, err := jwt.ParseWithClaims(cookie.Value, &types.Claims{}, func(token *jwt.Token) (any, error) {
-		return []byte(config.JWT_SECRET), nil
-	})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	// a reconnecting client gets what it missed before any live frame
+	var lastSeq int64
+	replaying := c.QueryParam("lastSeq") != ""
+	if replaying {
+		var err error
+		lastSeq, err = strconv.ParseInt(c.QueryParam("lastSeq"), 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "lastSeq must be a number")
+		}
 	}
-	claims := token.Claims.(*types.Claims)
 
 	conn, err := upgrader.Upgrade(c.Response().Writer, c.Request(), nil)
 	if err != nil {
@@ -154,73 +140,59 @@ func (ws WsChatHandler) Chat(c echo.Context) error {
 		return nil
 	})
 
-	done := make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(25 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second)); err != nil {
-					log.Println("Ping failed:", err)
-					return
-				}
-			case <-done:
-				return
-			}
-		}
+	con := newConnection(conn, claims.Name, claims.ID)
+	if replaying {
+		con.hold()
+	}
+	go con.writePump()
+	connection <- con
+
+	// unregister this connection however the socket ends, other tabs and
+	// devices of the user keep theirs
+	defer func() {
+		con.close()
+		closing <- con
 	}()
 
-	conn.SetCloseHandler(func(code int, text string) error {
-		close(done)
-		closing <- claims.ID
-		return nil
-	})
-
-	connection <- Connection{
-		conn:   conn,
-		name:   claims.Name,
-		userID: claims.ID,
+	if replaying {
+		<-con.registered
+		replayed, err := replay(con, lastSeq)
+		if err != nil {
+			log.Printf("Failed to replay events to %s: %v", claims.ID, err)
+		}
+		if err := con.release(replayed); err != nil {
+			log.Printf("Failed to release held events to %s: %v", claims.ID, err)
+		}
 	}
 
 	for {
-		var data Message
-		err := conn.ReadJSON(&data)
+		_, raw, err := conn.ReadMessage()
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
-		// Handle ping messages
-		if data.Type == "ping" {
-			data.Type = "pong"
-			if err := conn.WriteJSON(data); err != nil {
-				log.Println("Failed to send pong:", err)
+		data, perr := parseFrame(raw)
+		if perr == nil {
+			if data.Type == MessageTypePing {
+				if err := con.write(map[string]any{"type": MessageTypePong, "clientID": data.ClientID}); err != nil {
+					log.Println("Failed to send pong:", err)
+				}
+				continue
 			}
-			continue
+			perr = ws.authorize(claims.ID, &data)
 		}
-
-		if data.Type == "delete" {
-			channel, err := ws.srv.GetChannelById(data.ChannelID)
-			if err != nil {
-				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		if perr != nil {
+			// typing indicators are best effort, a rejected one isn't worth an error
+			if data.Type == MessageTypeTypingStart || data.Type == MessageTypeTypingStop {
+				continue
 			}
-			data.Recievers = channel.Participants()
-			messages <- data
+			if err := con.write(errorFrame(data.ClientID, perr)); err != nil {
+				log.Println("Failed to send error:", err)
+			}
+			continue
 		}
 
 		logger.LogDebug().Msg(fmt.Sprintf("Received message: %+v", data))
-		fmt.Println("received message", data)
-
-		var channel *db.ChannelModel
-		if data.ChannelID != "" {
-			channel, err = ws.srv.GetChannelById(data.ChannelID)
-			if err != nil {
-				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
-			}
-			data.Recievers = channel.Participants()
-			fmt.Println("the receivers are", data.Recievers)
-		}
-		data.SenderID = claims.ID
 		messages <- data
 	}
 }
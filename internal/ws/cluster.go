@@ -0,0 +1,294 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/redis"
+	r "github.com/redis/go-redis/v9"
+)
+
+// Every replica of the server only holds its own sockets. Frames are published
+// on deliverChannel and presence events on presenceChannel, and each replica
+// writes what it receives to the matching local connections.
+//
+// Connections are counted in Redis per instance, so a user stays connected
+// while any replica still has a socket open for them. A replica that dies
+// stops refreshing its heartbeat and the others reap its connections.
+const (
+	deliverChannel  = "ws:deliver"
+	presenceChannel = "ws:presence"
+
+	instancesKey      = "ws:instances"
+	heartbeatInterval = 10 * time.Second
+	heartbeatTTL      = 3 * heartbeatInterval
+)
+
+var instanceID = newInstanceID()
+
+// delivery carries either one numbered frame per user, or a single frame
+// for ephemeral events that are neither numbered nor replayed.
+type delivery struct {
+	Frames map[string]json.RawMessage `json:"frames,omitempty"`
+	Users  []string                   `json:"users,omitempty"`
+	Frame  json.RawMessage            `json:"frame,omitempty"`
+}
+
+type presenceDelivery struct {
+	WorkspaceID string      `json:"workspaceID"`
+	Event       OnlineEvent `json:"event"`
+}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func instanceKey(id string) string {
+	return "ws:instance:" + id
+}
+
+func instanceConnsKey(id string) string {
+	return "ws:instance:" + id + ":conns"
+}
+
+// trackScript counts a new connection of a member (a user in a hash such as
+// "connected") for this instance and cluster wide, and returns the cluster
+// wide count.
+var trackScript = r.NewScript(`
+local n = redis.call('HINCRBY', KEYS[1] .. ':count', ARGV[1], 1)
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('HINCRBY', KEYS[2], KEYS[1] .. '|' .. ARGV[1], 1)
+return n
+`)
+
+// untrackScript reverts trackScript and returns the remaining cluster wide
+// count, dropping the member from the hash when it reaches zero.
+var untrackScript = r.NewScript(`
+local field = KEYS[1] .. '|' .. ARGV[1]
+if redis.call('HINCRBY', KEYS[2], field, -1) <= 0 then
+  redis.call('HDEL', KEYS[2], field)
+end
+local n = redis.call('HINCRBY', KEYS[1] .. ':count', ARGV[1], -1)
+if n <= 0 then
+  redis.call('HDEL', KEYS[1] .. ':count', ARGV[1])
+  redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// reapScript removes every connection counted by a dead instance and returns
+// the "hash|member" pairs that went offline as a result.
+var reapScript = r.NewScript(`
+local offline = {}
+local conns = redis.call('HGETALL', KEYS[1])
+for i = 1, #conns, 2 do
+  local sep = string.find(conns[i], '|', 1, true)
+  local hash = string.sub(conns[i], 1, sep - 1)
+  local member = string.sub(conns[i], sep + 1)
+  local n = redis.call('HINCRBY', hash .. ':count', member, -tonumber(conns[i + 1]))
+  if n <= 0 then
+    redis.call('HDEL', hash .. ':count', member)
+    redis.call('HDEL', hash, member)
+    table.insert(offline, conns[i])
+  end
+end
+redis.call('DEL', KEYS[1])
+redis.call('SREM', KEYS[2], ARGV[1])
+return offline
+`)
+
+// track records a connection of member in hash and reports whether it is
+// the member's first one in the cluster.
+func track(hash, member, value string) bool {
+	n, err := trackScript.Run(context.Background(), redis.GetClient(),
+		[]string{hash, instanceConnsKey(instanceID)}, member, value,
+	).Int()
+	if err != nil {
+		log.Printf("Failed to track connection of %s: %v", member, err)
+		return false
+	}
+	return n == 1
+}
+
+// untrack removes a connection of member from hash and reports whether it
+// was the member's last one in the cluster.
+func untrack(hash, member string) bool {
+	n, err := untrackScript.Run(context.Background(), redis.GetClient(),
+		[]string{hash, instanceConnsKey(instanceID)}, member,
+	).Int()
+	if err != nil {
+		log.Printf("Failed to untrack connection of %s: %v", member, err)
+		return false
+	}
+	return n <= 0
+}
+
+// deliver publishes a frame for the given users, every replica writes it to
+// the connections it holds for them. Each copy is numbered and buffered so it
+// can be replayed.
+func deliver(userIDs []string, v any) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	frame, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	frames, err := sequence(userIDs, frame)
+	if err != nil {
+		return err
+	}
+	return publishDelivery(delivery{Frames: frames})
+}
+
+// deliverEphemeral publishes a frame that isn't worth replaying, such as a
+// typing indicator.
+func deliverEphemeral(userIDs []string, v any) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	frame, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return publishDelivery(delivery{Users: userIDs, Frame: frame})
+}
+
+func publishDelivery(d delivery) error {
+	payload, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return redis.GetClient().Publish(context.Background(), deliverChannel, payload).Err()
+}
+
+// connectedNames returns the names of the given users that are connected to
+// any replica, keyed by user ID.
+func connectedNames(userIDs []string) map[string]string {
+	names := make(map[string]string, len(userIDs))
+	if len(userIDs) == 0 {
+		return names
+	}
+	fields := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		fields[i] = "user:" + id
+	}
+	values, err := redis.GetClient().HMGet(context.Background(), "connected", fields...).Result()
+	if err != nil {
+		log.Printf("Failed to load connected users: %v", err)
+		return names
+	}
+	for i, value := range values {
+		if name, ok := value.(string); ok {
+			names[userIDs[i]] = name
+		}
+	}
+	return names
+}
+
+// Relay receives the frames and presence events published by every replica
+// and writes them to the local connections.
+func Relay() {
+	pubsub := redis.GetClient().Subscribe(context.Background(), deliverChannel, presenceChannel)
+	defer pubsub.Close()
+
+	for msg := range pubsub.Channel() {
+		switch msg.Channel {
+		case deliverChannel:
+			var d delivery
+			if err := json.Unmarshal([]byte(msg.Payload), &d); err != nil {
+				log.Printf("Failed to decode delivery: %v", err)
+				continue
+			}
+			mu.RLock()
+			for userID, frame := range d.Frames {
+				writeToUser(userID, frame, false)
+			}
+			for _, userID := range d.Users {
+				writeToUser(userID, d.Frame, true)
+			}
+			mu.RUnlock()
+
+		case presenceChannel:
+			var d presenceDelivery
+			if err := json.Unmarshal([]byte(msg.Payload), &d); err != nil {
+				log.Printf("Failed to decode presence event: %v", err)
+				continue
+			}
+			sendEventLocally(d.WorkspaceID, d.Event)
+		}
+	}
+}
+
+// Heartbeat keeps this instance registered and reaps the connections of the
+// instances that stopped sending theirs.
+func Heartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	beat()
+	for range ticker.C {
+		beat()
+	}
+}
+
+func beat() {
+	rdb := redis.GetClient()
+	ctx := context.Background()
+
+	if err := rdb.Set(ctx, instanceKey(instanceID), time.Now().Unix(), heartbeatTTL).Err(); err != nil {
+		log.Printf("Failed to send heartbeat: %v", err)
+		return
+	}
+	rdb.SAdd(ctx, instancesKey, instanceID)
+
+	instances, err := rdb.SMembers(ctx, instancesKey).Result()
+	if err != nil {
+		log.Printf("Failed to list instances: %v", err)
+		return
+	}
+	for _, id := range instances {
+		if id == instanceID {
+			continue
+		}
+		alive, err := rdb.Exists(ctx, instanceKey(id)).Result()
+		if err != nil || alive == 1 {
+			continue
+		}
+		reapInstance(id)
+	}
+}
+
+func reapInstance(id string) {
+	offline, err := reapScript.Run(context.Background(), redis.GetClient(),
+		[]string{instanceConnsKey(id), instancesKey}, id,
+	).StringSlice()
+	if err != nil {
+		log.Printf("Failed to reap instance %s: %v", id, err)
+		return
+	}
+	log.Printf("Reaped %d connections of instance %s", len(offline), id)
+
+	// tell the workspaces about the users that were only connected there
+	for _, entry := range offline {
+		hash, userID, ok := strings.Cut(entry, "|")
+		if !ok {
+			continue
+		}
+		if workspaceID, ok := strings.CutPrefix(hash, onlineKeyPrefix); ok {
+			broadcastEvent(workspaceID, OnlineEvent{
+				UserID: userID,
+				Event:  "disconnected",
+			})
+		}
+	}
+}
@@ -1,17 +1,17 @@
 package ws
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/CollabTED/CollabTed-Backend/internal/services"
 	"github.com/CollabTED/CollabTed-Backend/internal/sse"
 	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
-	"github.com/CollabTED/CollabTed-Backend/pkg/redis"
 	"github.com/CollabTED/CollabTed-Backend/pkg/types"
 	"github.com/CollabTED/CollabTed-Backend/prisma/db"
 	"github.com/gorilla/websocket"
@@ -19,22 +19,47 @@ import (
 
 var msgSrv = services.NewMessageService()
 var wrkSrv = services.NewWorkspaceService()
+var appStateSrv = services.NewAppStateService()
+var profileSrv = services.NewProfileService()
+var prefSrv = services.NewNotificationPreferenceService()
 
 type MessageType string
 
 const (
 	MessageTypeBroadcast    MessageType = "broadcast"
 	MessageTypeDelete       MessageType = "delete"
+	MessageTypeEdit         MessageType = "edit"
+	MessageTypeReaction     MessageType = "reaction"
+	MessageTypeRead         MessageType = "read"
 	MessageTypeBoard        MessageType = "board"
 	MessageTypePrivate      MessageType = "private"
 	MessageTypeSystem       MessageType = "system"
 	MessageTypeNotification MessageType = "notification"
+	MessageTypeThreadReply  MessageType = "thread_reply"
+	MessageTypeError        MessageType = "error"
+	MessageTypeTypingStart  MessageType = "typing_start"
+	MessageTypeTypingStop   MessageType = "typing_stop"
+	MessageTypePing         MessageType = "ping"
+	MessageTypePong         MessageType = "pong"
+	MessageTypeReplayGap    MessageType = "replay_gap"
+	MessageTypeReplayDone   MessageType = "replay_done"
 )
 
 type Connection struct {
 	// msgType     MessageType
 	conn *websocket.Conn
 	name string
+	// send queues the frames written by writePump
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	// registered is closed once the hub can deliver to the connection
+	registered chan struct{}
+	// while a reconnecting client is replayed what it missed, live frames
+	// are held here so they can't overtake the replayed ones
+	holdMu  sync.Mutex
+	holding bool
+	held    [][]byte
 	// workspaceID string
 	userID string
 }
@@ -48,23 +73,23 @@ type Message struct {
 	Content     string      `json:"content"`
 	WorkspaceID string      `json:"workspaceID"`
 
-	IsReply         bool   `json:"isReply"`
-	ReplyToMessage  string `json:"replyToMessage"`
-	ReplyToUserName string `json:"replyToUserName"`
+	ParentID string `json:"parentID"`
 
 	AttachmentTitle string `json:"attachmentTitle"`
 	AttachmentLink  string `json:"attachmentLink"`
 
-	Elements  []json.RawMessage `json:"elements"`
-	Recievers []db.UserWorkspaceModel
+	Elements []json.RawMessage `json:"elements"`
+	// Recievers is filled server side and never sent to clients
+	Recievers []db.UserWorkspaceModel `json:"-"`
 }
 
 var (
-	connection = make(chan Connection)
+	connection = make(chan *Connection)
 	messages   = make(chan Message)
-	closing    = make(chan string)
-	users      = make(map[string]Connection)
-	mu         sync.RWMutex
+	closing    = make(chan *Connection)
+	// users holds every open connection of a user, one per tab or device
+	users = make(map[string]map[*Connection]struct{})
+	mu    sync.RWMutex
 )
 
 var (
@@ -85,10 +110,15 @@ func Hub() {
 		case con := <-connection:
 			fmt.Printf("user: %s connected\n", con.userID)
 			mu.Lock()
-			rdb := redis.GetClient()
-			rdb.HSet(context.Background(), "connected", "user:"+con.userID, con.name)
-			users[con.userID] = con
+			track("connected", "user:"+con.userID, con.name)
+			conns, ok := users[con.userID]
+			if !ok {
+				conns = make(map[*Connection]struct{})
+				users[con.userID] = conns
+			}
+			conns[con] = struct{}{}
 			mu.Unlock()
+			close(con.registered)
 
 		case msg := <-messages:
 			logger.LogDebug().Msg(fmt.Sprintf("Received message: %+v", msg))
@@ -96,6 +126,7 @@ func Hub() {
 			switch msg.Type {
 			case MessageTypeBroadcast:
 				// Handle broadcasting messages to the entire channel
+				clearTyping(msg)
 				err := broadcastMessageToChannel(msg)
 				if err != nil {
 					log.Printf("Error broadcasting message: %v\n", err)
@@ -108,28 +139,51 @@ func Hub() {
 					log.Printf("Error deleting message: %v\n", err)
 				}
 
+			case MessageTypeEdit:
+				// Handle editing messages
+				err := broadcastEditMessage(msg)
+				if err != nil {
+					log.Printf("Error editing message: %v\n", err)
+				}
+
+			case MessageTypeReaction:
+				// Handle toggling a reaction on a message
+				err := broadcastReaction(msg)
+				if err != nil {
+					log.Printf("Error reacting to message: %v\n", err)
+				}
+
+			case MessageTypeRead:
+				// Handle moving the sender's read pointer in a channel
+				err := broadcastRead(msg)
+				if err != nil {
+					log.Printf("Error marking channel as read: %v\n", err)
+				}
+
 			case MessageTypePrivate:
-				// Handle sending private messages to individual users
-				for _, user := range msg.Recievers {
-					err := sendPrivateMessage(user.UserID, msg)
-					if err != nil {
-						log.Printf("Error sending private message to user %s: %v\n", user.UserID, err)
-					}
+				// Handle direct messages, saved and fanned out like broadcasts
+				clearTyping(msg)
+				err := broadcastMessageToChannel(msg)
+				if err != nil {
+					log.Printf("Error sending private message: %v\n", err)
 				}
+			case MessageTypeTypingStart:
+				// Handle ephemeral typing indicators, expired by the server
+				startTyping(msg)
+				broadcastTyping(msg)
+
+			case MessageTypeTypingStop:
+				stopTyping(msg)
+				broadcastTyping(msg)
+
 			case MessageTypeBoard:
 				workspace, err := wrkSrv.GetWorkspaceById(msg.WorkspaceID)
 				if err != nil {
 					log.Printf("Error getting workspace: %v\n", err)
 				}
-				for _, user := range workspace.Users() {
-					con, ok := users[user.UserID]
-					if !ok {
-						continue
-					}
-					err := con.conn.WriteJSON(msg)
-					if err != nil {
-						continue
-					}
+				err = deliver(userIDs(workspace.Users()), msg)
+				if err != nil {
+					log.Printf("Error sending board update: %v\n", err)
 				}
 
 			case MessageTypeNotification:
@@ -143,18 +197,29 @@ func Hub() {
 				}
 
 			case MessageTypeSystem:
-				// Handle system messages (log them or take other actions)
-				log.Printf("System message received: %s", msg.Content)
+				// Handle system messages, already saved by the services
+				err := broadcastSystemMessage(msg)
+				if err != nil {
+					log.Printf("Error sending system message: %v\n", err)
+				}
 
 			default:
 				log.Printf("Unknown message type: %s", msg.Type)
 			}
 
-		case id := <-closing:
-			fmt.Printf("user: %s disconnected\n", id)
+		case con := <-closing:
 			mu.Lock()
-			delete(users, id)
+			conns := users[con.userID]
+			delete(conns, con)
+			if len(conns) == 0 {
+				delete(users, con.userID)
+			}
 			mu.Unlock()
+			// the user stays connected while another tab or device is open,
+			// on this replica or another one
+			if untrack("connected", "user:"+con.userID) {
+				fmt.Printf("user: %s disconnected\n", con.userID)
+			}
 		}
 	}
 }
@@ -170,74 +235,97 @@ func Hub() {
 // 	return nil
 // }
 
-func sendPrivateMessage(userID string, msg Message) error {
-	mu.RLock()
-	defer mu.RUnlock()
-	con, ok := users[userID]
-	if !ok {
-		return fmt.Errorf("user %s not found", userID)
-	}
-	err := con.conn.WriteJSON(msg)
+func broadcastMessageToChannel(msg Message) error {
+	// Saving msgs to the db
+	savedMsg, err := msgSrv.SendMessage(types.MessageD{
+		Content:         msg.Content,
+		SenderID:        msg.SenderID,
+		ChannelID:       msg.ChannelID,
+		ParentID:        msg.ParentID,
+		AttachmentLink:  msg.AttachmentLink,
+		AttachmentTitle: msg.AttachmentTitle,
+	})
 	if err != nil {
-		log.Printf("Error sending private message to user %s: %v\n", userID, err)
 		return err
 	}
-	return nil
+	return fanOutMessage(savedMsg, msg.ClientID, msg.Type, msg.Recievers)
 }
 
-func broadcastMessageToChannel(msg Message) error {
+// fanOutMessage delivers an already saved message to the participants of its
+// channel and notifies them.
+func fanOutMessage(savedMsg *db.MessageModel, clientID string, msgType MessageType, participants []db.UserWorkspaceModel) error {
 	//this notifier needs to be initialized somewhere else asap
 	n := getNotifier()
 	if n == nil {
 		return errors.New("SSE notifier not initialized")
 	}
 
-	// Saving msgs to the db
-	savedMsg, err := msgSrv.SendMessage(types.MessageD{
-		Content:         msg.Content,
-		SenderID:        msg.SenderID,
-		ChannelID:       msg.ChannelID,
-		IsReply:         msg.IsReply,
-		ReplyToMessage:  msg.ReplyToMessage,
-		ReplyToUserName: msg.ReplyToUserName,
-		AttachmentLink:  msg.AttachmentLink,
-		AttachmentTitle: msg.AttachmentTitle,
+	// replies go out as thread_reply so clients can update the open thread
+	// and the reply count on the parent without refetching
+	parentID, isReply := savedMsg.ParentID()
+	replyCount := 0
+	if isReply {
+		msgType = MessageTypeThreadReply
+		parent, err := msgSrv.GetMessageById(parentID)
+		if err != nil {
+			return err
+		}
+		replyCount = parent.ReplyCount
+	}
+
+	mentioned := make(map[string]struct{}, len(savedMsg.MentionIds))
+	for _, id := range savedMsg.MentionIds {
+		mentioned[id] = struct{}{}
+	}
+
+	recipients := userIDs(participants)
+	err := deliver(recipients, map[string]any{
+		"clientID":        clientID,
+		"type":            string(msgType),
+		"id":              savedMsg.ID,
+		"content":         savedMsg.Content,
+		"senderID":        savedMsg.SenderID,
+		"channelID":       savedMsg.ChannelID,
+		"isReply":         savedMsg.IsReply,
+		"parentID":        parentID,
+		"replyCount":      replyCount,
+		"mentionIds":      savedMsg.MentionIds,
+		"attachmentTitle": savedMsg.AttachmentTitle,
+		"attachmentLink":  savedMsg.AttachmentLink,
 	})
 	if err != nil {
 		return err
 	}
 
-	mu.RLock()
-	defer mu.RUnlock()
-
-	for _, user := range msg.Recievers {
-		con, ok := users[user.UserID]
-		if !ok {
+	// every participant but the sender gets an inbox entry, only the ones
+	// connected to some replica get it pushed
+	connected := connectedNames(recipients)
+	sender := senderName(savedMsg.SenderID)
+	policy := prefSrv.LoadPolicy(recipients, savedMsg.ChannelID)
+	now := time.Now()
+	for _, user := range participants {
+		if user.UserID == savedMsg.SenderID {
 			continue
 		}
-		err := con.conn.WriteJSON(map[string]any{
-			"clientID":        msg.ClientID,
-			"type":            string(msg.Type),
-			"id":              savedMsg.ID,
-			"content":         savedMsg.Content,
-			"senderID":        savedMsg.SenderID,
-			"channelID":       savedMsg.ChannelID,
-			"isReply":         savedMsg.IsReply,
-			"replyToMessage":  savedMsg.ReplyToMessage,
-			"replyToUserName": savedMsg.ReplyToUserName,
-			"attachmentTitle": savedMsg.AttachmentTitle,
-			"attachmentLink":  savedMsg.AttachmentLink,
-		})
-		if err != nil {
-			log.Printf("Error sending message to user %s: %v\n", user.UserID, err)
+		_, isMentioned := mentioned[user.ID]
+		decision := pushDecision(policy, user.UserID, isMentioned, now, connected)
+		if isMentioned {
+			err = n.NotifyMention(user.UserID, types.MentionNotification{
+				Sender:    sender,
+				Content:   savedMsg.Content,
+				Channel:   savedMsg.ChannelID,
+				SenderID:  savedMsg.SenderID,
+				MessageID: savedMsg.ID,
+			}, decision)
+		} else {
+			err = n.NotifyPing(user.UserID, types.PingNotification{
+				Type:     types.MESSAGE_NOTIFICATION,
+				Sender:   sender,
+				Content:  savedMsg.Content,
+				Channel:  savedMsg.ChannelID,
+				SenderID: savedMsg.SenderID,
+			}, decision)
 		}
-		err = n.NotifyPing(user.UserID, types.PingNotification{
-			Type:     types.MESSAGE_NOTIFICATION,
-			Sender:   con.name,
-			Content:  savedMsg.Content,
-			Channel:  savedMsg.ChannelID,
-			SenderID: savedMsg.SenderID,
-		})
 		if err != nil {
 			log.Println(err)
 		}
@@ -246,45 +334,192 @@ func broadcastMessageToChannel(msg Message) error {
 	return nil
 }
 
+// broadcastDeleteMessage deletes the message and, only once that succeeded,
+// tells the participants. tombstone is set when the message was kept as an
+// empty placeholder for its replies.
 func broadcastDeleteMessage(msg Message) error {
-	mu.RLock()
-	defer mu.RUnlock()
+	tombstone, err := msgSrv.DeleteMessage(msg.SenderID, msg.ID)
+	if err != nil {
+		return err
+	}
+
+	err = deliver(userIDs(msg.Recievers), map[string]any{
+		"type":      MessageTypeDelete,
+		"id":        msg.ID,
+		"channelID": msg.ChannelID,
+		"tombstone": tombstone != nil,
+	})
+	if err != nil {
+		return fmt.Errorf("error sending delete message: %v", err)
+	}
+	return nil
+}
+
+func broadcastEditMessage(msg Message) error {
+	edited, mentioned, err := msgSrv.EditMessage(msg.SenderID, msg.ID, msg.Content)
+	if err != nil {
+		return err
+	}
+	return fanOutEdit(edited, mentioned, msg.Recievers)
+}
+
+// fanOutEdit sends an edited message to the participants of its channel and
+// notifies the ones it newly mentions.
+func fanOutEdit(edited *db.MessageModel, mentioned []string, recipients []db.UserWorkspaceModel) error {
+	editedAt, _ := edited.EditedAt()
+
+	err := deliver(userIDs(recipients), map[string]any{
+		"type":       MessageTypeEdit,
+		"id":         edited.ID,
+		"channelID":  edited.ChannelID,
+		"content":    edited.Content,
+		"mentionIds": edited.MentionIds,
+		"editedAt":   editedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("error sending edit message: %v", err)
+	}
+	notifyMentioned(edited, recipients, mentioned)
+	return nil
+}
 
-	for _, user := range msg.Recievers {
-		con, ok := users[user.UserID]
-		if !ok {
+// notifyMentioned sends a mention notification to the recipients whose user
+// workspace is in mentioned.
+func notifyMentioned(message *db.MessageModel, recipients []db.UserWorkspaceModel, mentioned []string) {
+	if len(mentioned) == 0 {
+		return
+	}
+	n := getNotifier()
+	ids := userIDs(recipients)
+	connected := connectedNames(ids)
+	sender := senderName(message.SenderID)
+	policy := prefSrv.LoadPolicy(ids, message.ChannelID)
+	now := time.Now()
+	for _, user := range recipients {
+		if user.UserID == message.SenderID || !slices.Contains(mentioned, user.ID) {
 			continue
 		}
-		err := con.conn.WriteJSON(map[string]any{
-			"type":      MessageTypeDelete,
-			"id":        msg.ID,
-			"channelID": msg.ChannelID,
-		})
+		err := n.NotifyMention(user.UserID, types.MentionNotification{
+			Sender:    sender,
+			Content:   message.Content,
+			Channel:   message.ChannelID,
+			SenderID:  message.SenderID,
+			MessageID: message.ID,
+		}, pushDecision(policy, user.UserID, true, now, connected))
 		if err != nil {
-			log.Printf("Error sending delete message to user %s: %v\n", user.UserID, err)
+			log.Println(err)
 		}
 	}
+}
+
+// pushDecision applies the user's preferences and holds the push back, keeping
+// only the inbox entry, when the user isn't connected to any replica.
+func pushDecision(policy *services.NotifyPolicy, userID string, mention bool, now time.Time, connected map[string]string) services.NotifyDecision {
+	decision := policy.Decide(userID, mention, now)
+	if _, live := connected[userID]; !live {
+		decision.Quiet = true
+	}
+	return decision
+}
+
+// senderName returns the display name of the author of a message.
+func senderName(userID string) string {
+	user, err := profileSrv.GetUser(userID)
+	if err != nil {
+		return ""
+	}
+	return user.Name
+}
 
-	err := msgSrv.DeleteMessage(msg.SenderID, msg.ID)
+// broadcastReaction toggles the sender's reaction, carried in Content, on the
+// message with the given ID and tells the channel whether it was added or removed.
+func broadcastReaction(msg Message) error {
+	userWorkspaceID, added, err := msgSrv.ToggleReaction(msg.SenderID, msg.ID, msg.Content)
 	if err != nil {
 		return err
 	}
+	action := "remove"
+	if added {
+		action = "add"
+	}
+
+	err = deliver(userIDs(msg.Recievers), map[string]any{
+		"type":            MessageTypeReaction,
+		"id":              msg.ID,
+		"channelID":       msg.ChannelID,
+		"emoji":           msg.Content,
+		"action":          action,
+		"userWorkspaceID": userWorkspaceID,
+	})
+	if err != nil {
+		return fmt.Errorf("error sending reaction: %v", err)
+	}
+	return nil
+}
+
+// broadcastRead marks the channel as read by the sender up to the message with
+// the given ID (or the latest one) and lets participants show who has seen it.
+func broadcastRead(msg Message) error {
+	read, err := appStateSrv.MarkChannelRead(msg.SenderID, msg.ChannelID, msg.ID)
+	if err != nil || read == nil {
+		return err
+	}
+
+	err = deliver(userIDs(msg.Recievers), map[string]any{
+		"type":            MessageTypeRead,
+		"id":              read.LastReadMessageID,
+		"channelID":       read.ChannelID,
+		"userID":          msg.SenderID,
+		"userWorkspaceID": read.UserWorkspaceID,
+		"readAt":          read.LastReadAt,
+	})
+	if err != nil {
+		return fmt.Errorf("error sending read receipt: %v", err)
+	}
 	return nil
 }
 
 func sendNotification(recipients []db.UserWorkspaceModel, msg Message) error {
-	mu.RLock()
-	defer mu.RUnlock()
+	return deliver(userIDs(recipients), map[string]any{
+		"type":      MessageTypeNotification,
+		"senderID":  msg.SenderID,
+		"channelID": msg.ChannelID,
+		"content":   msg.Content,
+	})
+}
+
+// broadcastSystemMessage delivers an already saved system message to its
+// recipients.
+func broadcastSystemMessage(msg Message) error {
+	return deliver(userIDs(msg.Recievers), map[string]any{
+		"type":      MessageTypeSystem,
+		"id":        msg.ID,
+		"senderID":  msg.SenderID,
+		"channelID": msg.ChannelID,
+		"content":   msg.Content,
+	})
+}
+
+func userIDs(recipients []db.UserWorkspaceModel) []string {
+	ids := make([]string, 0, len(recipients))
 	for _, user := range recipients {
-		con, ok := users[user.UserID]
-		if !ok {
-			continue // Skip users who are not connected
-		}
-		err := con.conn.WriteJSON(msg)
-		if err != nil {
-			log.Printf("Error sending notification to user %s: %v\n", user.UserID, err)
-			return err
-		}
+		ids = append(ids, user.UserID)
 	}
-	return nil
+	return ids
+}
+
+// writeToUser queues a frame on every open connection of the user on this
+// replica. Callers must hold mu.
+func writeToUser(userID string, frame []byte, ephemeral bool) {
+	for con := range users[userID] {
+		con.offer(frame, ephemeral)
+	}
+}
+
+// userName returns the display name of a connected user. Callers must hold mu.
+func userName(userID string) string {
+	for con := range users[userID] {
+		return con.name
+	}
+	return ""
 }
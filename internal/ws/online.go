@@ -1,9 +1,13 @@
 package ws
 
 import (
+	"context"
+	"encoding/json"
 	"log"
 	"sync"
 
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/pkg/redis"
 	"github.com/gorilla/websocket"
 )
 
@@ -15,9 +19,20 @@ type User struct {
 }
 
 type OnlineEvent struct {
-	UserID string   `json:"userID"`
-	Event  string   `json:"event"`
-	Users  []string `json:"users,omitempty"`
+	UserID     string   `json:"userID"`
+	Event      string   `json:"event"`
+	Users      []string `json:"users,omitempty"`
+	Presence   string   `json:"presence,omitempty"`
+	StatusText string   `json:"statusText,omitempty"`
+
+	// Statuses holds the presence of the users listed in an initial event
+	Statuses map[string]PresenceState `json:"statuses,omitempty"`
+}
+
+// PresenceState is what a user chose to show on top of being connected.
+type PresenceState struct {
+	Presence   string `json:"presence"`
+	StatusText string `json:"statusText"`
 }
 
 var (
@@ -25,10 +40,30 @@ var (
 	disconnected  = make(chan *User)
 	workspaceLock sync.Mutex
 	workspaces    = make(map[string][]*User)
+	presenceSrv   = services.NewPresenceService()
 )
 
-// BroadcastEvent sends an event to all users in a workspace.
+// onlineKeyPrefix is followed by a workspace ID. The hash lists the users
+// connected to /online in that workspace across every replica.
+const onlineKeyPrefix = "ws:online:"
+
+// BroadcastEvent sends an event to all users in a workspace, whichever
+// replica they are connected to.
 func broadcastEvent(workspaceID string, event OnlineEvent) {
+	payload, err := json.Marshal(presenceDelivery{WorkspaceID: workspaceID, Event: event})
+	if err != nil {
+		log.Printf("Failed to marshal event: %v", err)
+		return
+	}
+	err = redis.GetClient().Publish(context.Background(), presenceChannel, payload).Err()
+	if err != nil {
+		log.Printf("Failed to publish event: %v", err)
+	}
+}
+
+// sendEventLocally writes an event to the users of a workspace connected to
+// this replica.
+func sendEventLocally(workspaceID string, event OnlineEvent) {
 	workspaceLock.Lock()
 	defer workspaceLock.Unlock()
 
@@ -52,26 +87,37 @@ func broadcastEvent(workspaceID string, event OnlineEvent) {
 // WatchConnect handles new user connections.
 func WatchConnect() {
 	for user := range online {
-		workspaceLock.Lock()
-
-		// Get existing users BEFORE adding new user
-		existingUsers := workspaces[user.WorkspaceID]
+		// Get existing users BEFORE adding new user, from every replica
+		existingUsers, err := redis.GetClient().HKeys(context.Background(), onlineKeyPrefix+user.WorkspaceID).Result()
+		if err != nil {
+			log.Printf("Failed to load online users of %s: %v", user.WorkspaceID, err)
+		}
 
 		// Add the new user to the workspace
-		workspaces[user.WorkspaceID] = append(existingUsers, user)
-
+		workspaceLock.Lock()
+		workspaces[user.WorkspaceID] = append(workspaces[user.WorkspaceID], user)
 		workspaceLock.Unlock()
+		firstConnection := track(onlineKeyPrefix+user.WorkspaceID, user.UserID, "1")
+
+		statuses := workspacePresence(user.WorkspaceID)
 
 		// Send initial users to new connection
-		if len(existingUsers) > 0 {
-			initialUserIDs := make([]string, len(existingUsers))
-			for i, u := range existingUsers {
-				initialUserIDs[i] = u.UserID
+		initialUserIDs := make([]string, 0, len(existingUsers))
+		initialStatuses := make(map[string]PresenceState, len(existingUsers))
+		for _, id := range existingUsers {
+			// the user's other tabs or devices don't count
+			if id == user.UserID {
+				continue
 			}
+			initialUserIDs = append(initialUserIDs, id)
+			initialStatuses[id] = statuses[id]
+		}
+		if len(initialUserIDs) > 0 {
 
 			initialEvent := OnlineEvent{
-				Event: "initial",
-				Users: initialUserIDs,
+				Event:    "initial",
+				Users:    initialUserIDs,
+				Statuses: initialStatuses,
 			}
 
 			user.WriteMu.Lock()
@@ -83,10 +129,16 @@ func WatchConnect() {
 			}
 		}
 
-		// Broadcast the "connected" event to everyone
+		// Broadcast the "connected" event to everyone, once per user
+		if !firstConnection {
+			continue
+		}
+		state := statuses[user.UserID]
 		broadcastEvent(user.WorkspaceID, OnlineEvent{
-			UserID: user.UserID,
-			Event:  "connected",
+			UserID:     user.UserID,
+			Event:      "connected",
+			Presence:   state.Presence,
+			StatusText: state.StatusText,
 		})
 	}
 }
@@ -95,13 +147,13 @@ func WatchDisconnect() {
 	for user := range disconnected {
 		workspaceLock.Lock()
 
-		// Remove the user from the workspace
+		// Remove the connection from the workspace
 		users, ok := workspaces[user.WorkspaceID]
 		if ok && len(users) > 0 {
 			// Create slice without capacity restriction
 			updatedUsers := make([]*User, 0)
 			for _, u := range users {
-				if u.UserID != user.UserID {
+				if u != user {
 					updatedUsers = append(updatedUsers, u)
 				}
 			}
@@ -110,8 +162,8 @@ func WatchDisconnect() {
 
 		workspaceLock.Unlock()
 
-		// Broadcast the "disconnected" event
-		if ok {
+		// Broadcast the "disconnected" event when the last connection is gone
+		if ok && untrack(onlineKeyPrefix+user.WorkspaceID, user.UserID) {
 			broadcastEvent(user.WorkspaceID, OnlineEvent{
 				UserID: user.UserID,
 				Event:  "disconnected",
@@ -119,3 +171,32 @@ func WatchDisconnect() {
 		}
 	}
 }
+
+// BroadcastPresence tells a workspace that a user changed their presence or
+// status text.
+func BroadcastPresence(workspaceID, userID, presence, statusText string) {
+	broadcastEvent(workspaceID, OnlineEvent{
+		UserID:     userID,
+		Event:      "status",
+		Presence:   presence,
+		StatusText: statusText,
+	})
+}
+
+// workspacePresence loads the stored presence of every user of a workspace,
+// keyed by user ID.
+func workspacePresence(workspaceID string) map[string]PresenceState {
+	statuses := make(map[string]PresenceState)
+	users, err := presenceSrv.GetPresences(workspaceID)
+	if err != nil {
+		log.Printf("Failed to load presence for workspace %s: %v", workspaceID, err)
+		return statuses
+	}
+	for _, u := range users {
+		statuses[u.UserID] = PresenceState{
+			Presence:   string(u.Presence),
+			StatusText: u.StatusText,
+		}
+	}
+	return statuses
+}
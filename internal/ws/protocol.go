@@ -0,0 +1,326 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+// ProtocolVersion is the version of the client frames understood by /ws.
+//
+// A client frame is an envelope {"v": 1, "type": ..., "clientID": ...,
+// "payload": {...}} whose payload depends on the type. Frames without "v"
+// use the original flat format and are upgraded to an envelope before being
+// validated the same way.
+const ProtocolVersion = 1
+
+const maxContentLength = 4000
+
+type Envelope struct {
+	V        int             `json:"v"`
+	Type     MessageType     `json:"type"`
+	ClientID string          `json:"clientID"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+type ChatPayload struct {
+	ChannelID       string `json:"channelID"`
+	Content         string `json:"content"`
+	ParentID        string `json:"parentID"`
+	AttachmentTitle string `json:"attachmentTitle"`
+	AttachmentLink  string `json:"attachmentLink"`
+}
+
+// MessageRefPayload targets an existing message, for deletes and reads. For
+// reads the ID is optional and defaults to the latest message.
+type MessageRefPayload struct {
+	ChannelID string `json:"channelID"`
+	ID        string `json:"id"`
+}
+
+type EditPayload struct {
+	ChannelID string `json:"channelID"`
+	ID        string `json:"id"`
+	Content   string `json:"content"`
+}
+
+type ReactionPayload struct {
+	ChannelID string `json:"channelID"`
+	ID        string `json:"id"`
+	Emoji     string `json:"emoji"`
+}
+
+type TypingPayload struct {
+	ChannelID string `json:"channelID"`
+}
+
+type BoardPayload struct {
+	WorkspaceID string            `json:"workspaceID"`
+	Elements    []json.RawMessage `json:"elements"`
+}
+
+// ProtocolError is sent back to the client in an error frame when one of
+// its frames is rejected.
+type ProtocolError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func protocolError(code, format string, args ...any) *ProtocolError {
+	return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// errorFrame is the frame carrying a ProtocolError.
+func errorFrame(clientID string, perr *ProtocolError) map[string]any {
+	return map[string]any{
+		"v":        ProtocolVersion,
+		"type":     MessageTypeError,
+		"clientID": clientID,
+		"payload":  perr,
+	}
+}
+
+// parseFrame decodes a client frame into a Message, validating its payload
+// against the schema of its type. The sender, receivers and anything the
+// server derives are left for authorize to fill in.
+func parseFrame(raw []byte) (Message, *ProtocolError) {
+	env, perr := decodeEnvelope(raw)
+	if perr != nil {
+		return Message{}, perr
+	}
+
+	msg := Message{ClientID: env.ClientID, Type: env.Type}
+	switch env.Type {
+	case MessageTypePing:
+		return msg, nil
+
+	case MessageTypeBroadcast, MessageTypePrivate:
+		var p ChatPayload
+		if perr := decodePayload(env, &p); perr != nil {
+			return msg, perr
+		}
+		if strings.TrimSpace(p.Content) == "" && p.AttachmentLink == "" {
+			return msg, protocolError("invalid_payload", "content or attachmentLink is required")
+		}
+		if perr := checkContent(p.Content); perr != nil {
+			return msg, perr
+		}
+		msg.ChannelID = p.ChannelID
+		msg.Content = p.Content
+		msg.ParentID = p.ParentID
+		msg.AttachmentTitle = p.AttachmentTitle
+		msg.AttachmentLink = p.AttachmentLink
+
+	case MessageTypeDelete, MessageTypeRead:
+		var p MessageRefPayload
+		if perr := decodePayload(env, &p); perr != nil {
+			return msg, perr
+		}
+		if env.Type == MessageTypeDelete && p.ID == "" {
+			return msg, protocolError("invalid_payload", "id is required")
+		}
+		msg.ChannelID = p.ChannelID
+		msg.ID = p.ID
+
+	case MessageTypeEdit:
+		var p EditPayload
+		if perr := decodePayload(env, &p); perr != nil {
+			return msg, perr
+		}
+		if p.ID == "" || strings.TrimSpace(p.Content) == "" {
+			return msg, protocolError("invalid_payload", "id and content are required")
+		}
+		if perr := checkContent(p.Content); perr != nil {
+			return msg, perr
+		}
+		msg.ChannelID = p.ChannelID
+		msg.ID = p.ID
+		msg.Content = p.Content
+
+	case MessageTypeReaction:
+		var p ReactionPayload
+		if perr := decodePayload(env, &p); perr != nil {
+			return msg, perr
+		}
+		if p.ID == "" || p.Emoji == "" || utf8.RuneCountInString(p.Emoji) > 32 {
+			return msg, protocolError("invalid_payload", "id and a short emoji are required")
+		}
+		msg.ChannelID = p.ChannelID
+		msg.ID = p.ID
+		// the hub carries the emoji in Content
+		msg.Content = p.Emoji
+
+	case MessageTypeTypingStart, MessageTypeTypingStop:
+		var p TypingPayload
+		if perr := decodePayload(env, &p); perr != nil {
+			return msg, perr
+		}
+		msg.ChannelID = p.ChannelID
+
+	case MessageTypeBoard:
+		var p BoardPayload
+		if perr := decodePayload(env, &p); perr != nil {
+			return msg, perr
+		}
+		if p.WorkspaceID == "" {
+			return msg, protocolError("invalid_payload", "workspaceID is required")
+		}
+		msg.WorkspaceID = p.WorkspaceID
+		msg.Elements = p.Elements
+
+	default:
+		// notifications, system messages and anything else only come from
+		// the server
+		return msg, protocolError("unknown_type", "unknown message type %q", env.Type)
+	}
+
+	if msg.Type != MessageTypeBoard && msg.ChannelID == "" {
+		return msg, protocolError("invalid_payload", "channelID is required")
+	}
+	return msg, nil
+}
+
+func decodeEnvelope(raw []byte) (Envelope, *ProtocolError) {
+	var env Envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return env, protocolError("invalid_frame", "frame is not valid JSON")
+	}
+
+	switch env.V {
+	case 0:
+		return legacyEnvelope(raw, env)
+	case ProtocolVersion:
+		return env, nil
+	default:
+		return env, protocolError("unsupported_version", "protocol version %d is not supported", env.V)
+	}
+}
+
+// legacyEnvelope wraps a flat frame of the original protocol, where every
+// type shared the fields of Message, into an envelope.
+func legacyEnvelope(raw []byte, env Envelope) (Envelope, *ProtocolError) {
+	var legacy struct {
+		ChannelID       string            `json:"channelID"`
+		ID              string            `json:"id"`
+		Content         string            `json:"content"`
+		ParentID        string            `json:"parentID"`
+		AttachmentTitle string            `json:"attachmentTitle"`
+		AttachmentLink  string            `json:"attachmentLink"`
+		WorkspaceID     string            `json:"workspaceID"`
+		Elements        []json.RawMessage `json:"elements"`
+	}
+	if err := json.Unmarshal(raw, &legacy); err != nil {
+		return env, protocolError("invalid_frame", "frame is not valid JSON")
+	}
+
+	var payload any
+	switch env.Type {
+	case MessageTypeBroadcast, MessageTypePrivate:
+		payload = ChatPayload{
+			ChannelID:       legacy.ChannelID,
+			Content:         legacy.Content,
+			ParentID:        legacy.ParentID,
+			AttachmentTitle: legacy.AttachmentTitle,
+			AttachmentLink:  legacy.AttachmentLink,
+		}
+	case MessageTypeEdit:
+		payload = EditPayload{ChannelID: legacy.ChannelID, ID: legacy.ID, Content: legacy.Content}
+	case MessageTypeReaction:
+		payload = ReactionPayload{ChannelID: legacy.ChannelID, ID: legacy.ID, Emoji: legacy.Content}
+	case MessageTypeBoard:
+		payload = BoardPayload{WorkspaceID: legacy.WorkspaceID, Elements: legacy.Elements}
+	default:
+		payload = MessageRefPayload{ChannelID: legacy.ChannelID, ID: legacy.ID}
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return env, protocolError("invalid_frame", "frame can't be upgraded")
+	}
+	env.V = ProtocolVersion
+	env.Payload = b
+	return env, nil
+}
+
+func decodePayload(env Envelope, v any) *ProtocolError {
+	if len(env.Payload) == 0 {
+		return protocolError("invalid_payload", "payload is required for %q", env.Type)
+	}
+	if err := json.Unmarshal(env.Payload, v); err != nil {
+		return protocolError("invalid_payload", "invalid payload for %q: %v", env.Type, err)
+	}
+	return nil
+}
+
+func checkContent(content string) *ProtocolError {
+	if utf8.RuneCountInString(content) > maxContentLength {
+		return protocolError("invalid_payload", "content can't be longer than %d characters", maxContentLength)
+	}
+	return nil
+}
+
+// authorize checks the sender may act on the channel or workspace the frame
+// targets, and fills in the sender and receivers from the server's records.
+func (ws WsChatHandler) authorize(senderID string, msg *Message) *ProtocolError {
+	msg.SenderID = senderID
+
+	if msg.Type == MessageTypeBoard {
+		workspace, err := wrkSrv.GetWorkspaceById(msg.WorkspaceID)
+		if err != nil {
+			return protocolError("not_found", "workspace not found")
+		}
+		for _, user := range workspace.Users() {
+			if user.UserID == senderID {
+				return nil
+			}
+		}
+		return protocolError("forbidden", "you are not a member of this workspace")
+	}
+
+	channel, err := ws.srv.GetChannelById(msg.ChannelID)
+	if err != nil {
+		return protocolError("not_found", "channel not found")
+	}
+	if !isParticipant(channel, senderID) {
+		return protocolError("forbidden", "you are not a participant of this channel")
+	}
+	msg.Recievers = channel.Participants()
+
+	if msg.Type == MessageTypePrivate && !ws.srv.IsDirect(channel) {
+		return protocolError("forbidden", "private messages can only be sent in direct channels")
+	}
+	// archived channels are read-only, only read pointers may still move
+	if channel.IsArchived && msg.Type != MessageTypeRead {
+		return protocolError("channel_archived", "channel is archived")
+	}
+
+	// the message acted upon must belong to the channel the frame fans out to
+	switch msg.Type {
+	case MessageTypeDelete, MessageTypeEdit, MessageTypeReaction:
+		target, err := msgSrv.GetMessageById(msg.ID)
+		if err != nil || target.ChannelID != channel.ID {
+			return protocolError("not_found", "message not found in this channel")
+		}
+		// only the author may change a message, anyone may react to it
+		if msg.Type != MessageTypeReaction && target.SenderID != senderID {
+			return protocolError("forbidden", "you can only change your own messages")
+		}
+	}
+	return nil
+}
+
+func isParticipant(channel *db.ChannelModel, userID string) bool {
+	for _, user := range channel.Participants() {
+		if user.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package ws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFrame(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want Message
+		code string
+	}{
+		{
+			name: "broadcast",
+			raw:  `{"v":1,"type":"broadcast","clientID":"c1","payload":{"channelID":"ch","content":"hi"}}`,
+			want: Message{ClientID: "c1", Type: MessageTypeBroadcast, ChannelID: "ch", Content: "hi"},
+		},
+		{
+			name: "reply",
+			raw:  `{"v":1,"type":"broadcast","payload":{"channelID":"ch","content":"hi","parentID":"p"}}`,
+			want: Message{Type: MessageTypeBroadcast, ChannelID: "ch", Content: "hi", ParentID: "p"},
+		},
+		{
+			name: "legacy broadcast",
+			raw:  `{"type":"broadcast","channelID":"ch","content":"hi"}`,
+			want: Message{Type: MessageTypeBroadcast, ChannelID: "ch", Content: "hi"},
+		},
+		{
+			name: "legacy reaction carries the emoji in content",
+			raw:  `{"type":"reaction","channelID":"ch","id":"m","content":"👍"}`,
+			want: Message{Type: MessageTypeReaction, ChannelID: "ch", ID: "m", Content: "👍"},
+		},
+		{
+			name: "ping",
+			raw:  `{"v":1,"type":"ping","clientID":"c1"}`,
+			want: Message{ClientID: "c1", Type: MessageTypePing},
+		},
+		{
+			name: "read without id",
+			raw:  `{"v":1,"type":"read","payload":{"channelID":"ch"}}`,
+			want: Message{Type: MessageTypeRead, ChannelID: "ch"},
+		},
+		{
+			name: "board",
+			raw:  `{"v":1,"type":"board","payload":{"workspaceID":"w"}}`,
+			want: Message{Type: MessageTypeBoard, WorkspaceID: "w"},
+		},
+		{name: "not json", raw: `{`, code: "invalid_frame"},
+		{name: "unsupported version", raw: `{"v":2,"type":"broadcast"}`, code: "unsupported_version"},
+		{name: "server only type", raw: `{"v":1,"type":"system","payload":{}}`, code: "unknown_type"},
+		{name: "missing payload", raw: `{"v":1,"type":"edit"}`, code: "invalid_payload"},
+		{name: "empty message", raw: `{"v":1,"type":"broadcast","payload":{"channelID":"ch","content":"  "}}`, code: "invalid_payload"},
+		{name: "missing channel", raw: `{"v":1,"type":"broadcast","payload":{"content":"hi"}}`, code: "invalid_payload"},
+		{name: "delete without id", raw: `{"v":1,"type":"delete","payload":{"channelID":"ch"}}`, code: "invalid_payload"},
+		{name: "ack is no longer part of the protocol", raw: `{"v":1,"type":"ack","payload":{"seq":7}}`, code: "unknown_type"},
+		{name: "long emoji", raw: `{"v":1,"type":"reaction","payload":{"channelID":"ch","id":"m","emoji":"` + strings.Repeat("a", 33) + `"}}`, code: "invalid_payload"},
+		{name: "board without workspace", raw: `{"v":1,"type":"board","payload":{}}`, code: "invalid_payload"},
+		{
+			name: "content too long",
+			raw:  `{"v":1,"type":"broadcast","payload":{"channelID":"ch","content":"` + strings.Repeat("a", maxContentLength+1) + `"}}`,
+			code: "invalid_payload",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, perr := parseFrame([]byte(tt.raw))
+			if tt.code != "" {
+				if perr == nil || perr.Code != tt.code {
+					t.Fatalf("parseFrame() error = %v, want code %q", perr, tt.code)
+				}
+				return
+			}
+			if perr != nil {
+				t.Fatalf("parseFrame() error = %v", perr)
+			}
+			if got.ClientID != tt.want.ClientID || got.Type != tt.want.Type ||
+				got.ChannelID != tt.want.ChannelID || got.ID != tt.want.ID ||
+				got.Content != tt.want.Content || got.ParentID != tt.want.ParentID ||
+				got.WorkspaceID != tt.want.WorkspaceID {
+				t.Errorf("parseFrame() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// Frames for a /ws connection are queued and written by a single writer
+// goroutine per connection, so a slow client only ever holds up itself. When
+// its queue is full, ephemeral frames are dropped and anything else
+// disconnects the client, which catches up by replaying on reconnect.
+const (
+	sendQueueSize = 256
+	writeWait     = 10 * time.Second
+	pingPeriod    = 25 * time.Second
+)
+
+var (
+	errConnectionClosed = errors.New("connection closed")
+	errSlowConsumer     = errors.New("client is not reading fast enough")
+
+	droppedFrames   atomic.Int64
+	slowDisconnects atomic.Int64
+)
+
+func newConnection(conn *websocket.Conn, name, userID string) *Connection {
+	return &Connection{
+		conn:       conn,
+		name:       name,
+		userID:     userID,
+		send:       make(chan []byte, sendQueueSize),
+		done:       make(chan struct{}),
+		registered: make(chan struct{}),
+	}
+}
+
+// writePump is the only goroutine writing to the socket. It also keeps the
+// connection alive with pings and closes the socket when it stops, which
+// ends the read loop of the connection.
+func (con *Connection) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		con.conn.Close()
+	}()
+
+	for {
+		select {
+		case frame := <-con.send:
+			if err := con.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+				con.close()
+				return
+			}
+			if err := con.conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				log.Printf("Error writing to user %s: %v\n", con.userID, err)
+				con.close()
+				return
+			}
+
+		case <-ticker.C:
+			if err := con.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				log.Println("Ping failed:", err)
+				con.close()
+				return
+			}
+
+		case <-con.done:
+			return
+		}
+	}
+}
+
+// offer queues a frame without blocking, applying the slow consumer policy
+// when the queue is full. It reports whether the frame was queued. During a
+// replay the frame is held instead, ephemeral ones are dropped.
+func (con *Connection) offer(frame []byte, ephemeral bool) bool {
+	select {
+	case <-con.done:
+		return false
+	default:
+	}
+
+	con.holdMu.Lock()
+	if con.holding {
+		defer con.holdMu.Unlock()
+		if ephemeral {
+			droppedFrames.Add(1)
+			return false
+		}
+		if len(con.held) >= replayBufferSize {
+			slowDisconnects.Add(1)
+			log.Printf("Disconnecting %s, %d frames held during replay\n", con.userID, len(con.held))
+			con.close()
+			return false
+		}
+		con.held = append(con.held, frame)
+		return true
+	}
+	con.holdMu.Unlock()
+
+	select {
+	case con.send <- frame:
+		return true
+	default:
+	}
+
+	if ephemeral {
+		droppedFrames.Add(1)
+		return false
+	}
+	slowDisconnects.Add(1)
+	log.Printf("Disconnecting slow consumer %s with %d queued frames\n", con.userID, len(con.send))
+	con.close()
+	return false
+}
+
+// write queues a frame for the connection's own replies, waiting for room
+// in the queue up to writeWait.
+func (con *Connection) write(v any) error {
+	frame, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return con.queue(frame)
+}
+
+// queue waits up to writeWait for room in the queue, disconnecting the
+// client if none frees up.
+func (con *Connection) queue(frame []byte) error {
+	select {
+	case con.send <- frame:
+		return nil
+	case <-con.done:
+		return errConnectionClosed
+	case <-time.After(writeWait):
+		slowDisconnects.Add(1)
+		con.close()
+		return errSlowConsumer
+	}
+}
+
+// hold makes offer hold live frames until release. It is called before the
+// connection is registered so that no frame gets ahead of the replay.
+func (con *Connection) hold() {
+	con.holdMu.Lock()
+	con.holding = true
+	con.holdMu.Unlock()
+}
+
+// release queues the frames held during a replay, except those the replay
+// already wrote, and stops holding once none are left.
+func (con *Connection) release(replayedSeq int64) error {
+	for {
+		con.holdMu.Lock()
+		held := con.held
+		con.held = nil
+		if len(held) == 0 {
+			con.holding = false
+			con.holdMu.Unlock()
+			return nil
+		}
+		con.holdMu.Unlock()
+
+		for _, frame := range held {
+			var f struct {
+				Seq int64 `json:"seq"`
+			}
+			if json.Unmarshal(frame, &f) == nil && f.Seq > 0 && f.Seq <= replayedSeq {
+				continue
+			}
+			if err := con.queue(frame); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (con *Connection) close() {
+	con.closeOnce.Do(func() {
+		close(con.done)
+	})
+}
+
+type QueueStats struct {
+	Users           int   `json:"users"`
+	Connections     int   `json:"connections"`
+	QueuedFrames    int   `json:"queuedFrames"`
+	MaxQueueDepth   int   `json:"maxQueueDepth"`
+	QueueCapacity   int   `json:"queueCapacity"`
+	DroppedFrames   int64 `json:"droppedFrames"`
+	SlowDisconnects int64 `json:"slowDisconnects"`
+}
+
+// Stats reports the outbound queues of the connections held by this replica.
+func Stats(c echo.Context) error {
+	stats := QueueStats{
+		QueueCapacity:   sendQueueSize,
+		DroppedFrames:   droppedFrames.Load(),
+		SlowDisconnects: slowDisconnects.Load(),
+	}
+
+	mu.RLock()
+	stats.Users = len(users)
+	for _, conns := range users {
+		for con := range conns {
+			depth := len(con.send)
+			stats.Connections++
+			stats.QueuedFrames += depth
+			if depth > stats.MaxQueueDepth {
+				stats.MaxQueueDepth = depth
+			}
+		}
+	}
+	mu.RUnlock()
+
+	return c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,132 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/redis"
+	r "github.com/redis/go-redis/v9"
+)
+
+// Every frame delivered to a user carries a "seq" that grows by one per user,
+// across all of their devices. The latest frames are buffered in Redis so a
+// client that reconnects with ?lastSeq= gets what it missed. The buffer is
+// shared by all devices of the user, so it is only trimmed by size and age.
+// A client that fell behind further than the buffer gets replay_gap and
+// should refetch over REST.
+const (
+	replayBufferSize = 500
+	replayBufferTTL  = 24 * time.Hour
+	// replayBatchSize is how many buffered frames are read from Redis at
+	// once, each batch is queued before the next is read
+	replayBatchSize = 100
+)
+
+func seqKey(userID string) string {
+	return "ws:seq:" + userID
+}
+
+func bufferKey(userID string) string {
+	return "ws:buffer:" + userID
+}
+
+// sequence numbers a copy of frame for each user and buffers it for replay.
+func sequence(userIDs []string, frame []byte) (map[string]json.RawMessage, error) {
+	ctx := context.Background()
+	rdb := redis.GetClient()
+
+	pipe := rdb.Pipeline()
+	seqs := make([]*r.IntCmd, len(userIDs))
+	for i, id := range userIDs {
+		seqs[i] = pipe.Incr(ctx, seqKey(id))
+		pipe.Expire(ctx, seqKey(id), replayBufferTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	frames := make(map[string]json.RawMessage, len(userIDs))
+	pipe = rdb.Pipeline()
+	for i, id := range userIDs {
+		seq := seqs[i].Val()
+		numbered, err := withSeq(frame, seq)
+		if err != nil {
+			return nil, err
+		}
+		frames[id] = numbered
+
+		pipe.ZAdd(ctx, bufferKey(id), r.Z{Score: float64(seq), Member: string(numbered)})
+		pipe.ZRemRangeByRank(ctx, bufferKey(id), 0, -replayBufferSize-1)
+		pipe.Expire(ctx, bufferKey(id), replayBufferTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return frames, nil
+}
+
+func withSeq(frame []byte, seq int64) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(frame, &fields); err != nil {
+		return nil, err
+	}
+	fields["seq"] = json.RawMessage(strconv.FormatInt(seq, 10))
+	return json.Marshal(fields)
+}
+
+// replay writes the frames buffered after lastSeq to a reconnecting client,
+// preceded by a replay_gap when some of them are no longer buffered, and
+// ends with replay_done. Live frames are held by the connection meanwhile.
+// It returns the seq of the last frame written, the held frames up to it
+// are duplicates.
+func replay(con *Connection, lastSeq int64) (int64, error) {
+	ctx := context.Background()
+	rdb := redis.GetClient()
+
+	current, err := rdb.Get(ctx, seqKey(con.userID)).Int64()
+	if err != nil && err != r.Nil {
+		return lastSeq, err
+	}
+
+	replayed := lastSeq
+	for first := true; ; first = false {
+		batch, err := rdb.ZRangeArgsWithScores(ctx, r.ZRangeArgs{
+			Key:     bufferKey(con.userID),
+			Start:   "(" + strconv.FormatInt(replayed, 10),
+			Stop:    "+inf",
+			ByScore: true,
+			Count:   replayBatchSize,
+		}).Result()
+		if err != nil {
+			return replayed, err
+		}
+
+		if first && current > lastSeq && (len(batch) == 0 || int64(batch[0].Score) > lastSeq+1) {
+			err := con.write(map[string]any{
+				"type": MessageTypeReplayGap,
+				"seq":  current,
+			})
+			if err != nil {
+				return replayed, err
+			}
+		}
+
+		for _, z := range batch {
+			member, _ := z.Member.(string)
+			if err := con.queue([]byte(member)); err != nil {
+				return replayed, err
+			}
+			replayed = int64(z.Score)
+		}
+		if len(batch) < replayBatchSize {
+			break
+		}
+	}
+
+	return replayed, con.write(map[string]any{
+		"type": MessageTypeReplayDone,
+		"seq":  max(current, replayed),
+	})
+}
@@ -0,0 +1,116 @@
+package ws
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/internal/services"
+	"github.com/CollabTED/CollabTed-Backend/pkg/types"
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
+
+const scheduleInterval = 15 * time.Second
+
+var (
+	scheduledSrv = services.NewScheduledMessageService()
+	channelSrv   = services.NewChannelService()
+)
+
+// DispatchScheduled delivers scheduled messages and reminders once they are
+// due. Messages are saved before the entry is marked as sent and only then
+// fanned out, exactly like the ones sent over the socket.
+func DispatchScheduled() {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		due, err := scheduledSrv.ClaimDue(now)
+		if err != nil {
+			log.Printf("Error claiming scheduled messages: %v\n", err)
+			continue
+		}
+
+		for _, scheduled := range due {
+			if scheduled.Kind == db.ScheduledKindReminder {
+				err := sendReminder(scheduled)
+				markScheduled(scheduled.ID, err)
+				continue
+			}
+
+			saved, channel, err := postScheduledMessage(scheduled)
+			markScheduled(scheduled.ID, err)
+			if err != nil {
+				continue
+			}
+
+			msgType := MessageTypeBroadcast
+			if channelSrv.IsDirect(channel) {
+				msgType = MessageTypePrivate
+			}
+			if err := fanOutMessage(saved, "", msgType, channel.Participants()); err != nil {
+				log.Printf("Error sending scheduled message %s: %v\n", scheduled.ID, err)
+			}
+		}
+	}
+}
+
+// markScheduled records the outcome of dispatching a scheduled entry.
+func markScheduled(scheduledID string, err error) {
+	if err != nil {
+		log.Printf("Error dispatching scheduled message %s: %v\n", scheduledID, err)
+		err = scheduledSrv.MarkFailed(scheduledID, err)
+	} else {
+		err = scheduledSrv.MarkSent(scheduledID)
+	}
+	if err != nil {
+		log.Printf("Error updating scheduled message %s: %v\n", scheduledID, err)
+	}
+}
+
+// postScheduledMessage saves a scheduled message to its channel, provided
+// the sender is still a participant of it.
+func postScheduledMessage(scheduled db.ScheduledMessageModel) (*db.MessageModel, *db.ChannelModel, error) {
+	channel, err := channelSrv.GetChannelById(scheduled.ChannelID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if channel.IsArchived {
+		return nil, nil, services.ErrChannelArchived
+	}
+	isParticipant := slices.ContainsFunc(channel.Participants(), func(user db.UserWorkspaceModel) bool {
+		return user.UserID == scheduled.SenderID
+	})
+	if !isParticipant {
+		return nil, nil, errors.New("sender is no longer a participant of the channel")
+	}
+
+	parentID, _ := scheduled.ParentID()
+	saved, err := msgSrv.SendMessage(types.MessageD{
+		SenderID:        scheduled.SenderID,
+		ChannelID:       scheduled.ChannelID,
+		Content:         scheduled.Content,
+		ParentID:        parentID,
+		AttachmentLink:  scheduled.AttachmentLink,
+		AttachmentTitle: scheduled.AttachmentTitle,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return saved, channel, nil
+}
+
+func sendReminder(scheduled db.ScheduledMessageModel) error {
+	n := getNotifier()
+	if n == nil {
+		return errors.New("SSE notifier not initialized")
+	}
+
+	messageID, _ := scheduled.MessageID()
+	return n.NotifyReminder(scheduled.SenderID, types.ReminderNotification{
+		Content:   scheduled.Content,
+		Channel:   scheduled.ChannelID,
+		MessageID: messageID,
+	})
+}
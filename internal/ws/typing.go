@@ -0,0 +1,104 @@
+package ws
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// typingTimeout is how long a typing indicator lives without being renewed
+// by another typing_start.
+const typingTimeout = 6 * time.Second
+
+type typingKey struct {
+	channelID string
+	userID    string
+}
+
+var (
+	typingMu sync.Mutex
+	typing   = make(map[typingKey]*time.Timer)
+)
+
+// startTyping arms, or re-arms, the expiry of a typing indicator. If the
+// client doesn't renew it in time a typing_stop is sent on its behalf.
+func startTyping(msg Message) {
+	key := typingKey{channelID: msg.ChannelID, userID: msg.SenderID}
+	stop := msg
+	stop.Type = MessageTypeTypingStop
+
+	typingMu.Lock()
+	defer typingMu.Unlock()
+
+	if timer, ok := typing[key]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(typingTimeout, func() {
+		typingMu.Lock()
+		expired := typing[key] == timer
+		if expired {
+			delete(typing, key)
+		}
+		typingMu.Unlock()
+
+		if expired {
+			messages <- stop
+		}
+	})
+	typing[key] = timer
+}
+
+// stopTyping drops the typing indicator of the sender and reports whether
+// one was active.
+func stopTyping(msg Message) bool {
+	key := typingKey{channelID: msg.ChannelID, userID: msg.SenderID}
+
+	typingMu.Lock()
+	defer typingMu.Unlock()
+
+	timer, ok := typing[key]
+	if ok {
+		timer.Stop()
+		delete(typing, key)
+	}
+	return ok
+}
+
+// clearTyping stops the sender's typing indicator once their message is
+// sent, so the others don't wait for it to expire.
+func clearTyping(msg Message) {
+	if stopTyping(msg) {
+		stop := msg
+		stop.Type = MessageTypeTypingStop
+		broadcastTyping(stop)
+	}
+}
+
+// broadcastTyping fans a typing event out to the other participants of the
+// channel. Typing events are never saved.
+func broadcastTyping(msg Message) {
+	mu.RLock()
+	senderName := userName(msg.SenderID)
+	mu.RUnlock()
+
+	event := map[string]any{
+		"type":       msg.Type,
+		"channelID":  msg.ChannelID,
+		"senderID":   msg.SenderID,
+		"senderName": senderName,
+	}
+	if msg.Type == MessageTypeTypingStart {
+		event["expiresIn"] = int(typingTimeout.Seconds())
+	}
+
+	others := make([]string, 0, len(msg.Recievers))
+	for _, user := range msg.Recievers {
+		if user.UserID != msg.SenderID {
+			others = append(others, user.UserID)
+		}
+	}
+	if err := deliverEphemeral(others, event); err != nil {
+		log.Printf("Error sending typing event: %v\n", err)
+	}
+}
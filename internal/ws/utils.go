@@ -1,6 +1,8 @@
 package ws
 
-import "github.com/CollabTED/CollabTed-Backend/prisma/db"
+import (
+	"github.com/CollabTED/CollabTed-Backend/prisma/db"
+)
 
 // These functions can be used from any part of the code to send data to the users real time
 
@@ -29,3 +31,38 @@ func SendMessage(senderID string, channelID string, content string, recipients [
 	// Push the message into the messages channel for broadcasting
 	messages <- msg
 }
+
+// SendSystemMessage delivers an already saved system message, such as a
+// membership announcement, to the specified recipients.
+func SendSystemMessage(message *db.MessageModel, recipients []db.UserWorkspaceModel) {
+	msg := Message{
+		ID:        message.ID,
+		SenderID:  message.SenderID,
+		ChannelID: message.ChannelID,
+		Content:   message.Content,
+		Type:      MessageTypeSystem,
+		Recievers: recipients,
+	}
+	messages <- msg
+}
+
+// SendChatMessage fans out a message saved outside of a socket, such as by
+// an incoming webhook, to the participants of its channel exactly like a
+// broadcast.
+func SendChatMessage(channel *db.ChannelModel, saved *db.MessageModel) error {
+	msgType := MessageTypeBroadcast
+	if channelSrv.IsDirect(channel) {
+		msgType = MessageTypePrivate
+	}
+	return fanOutMessage(saved, "", msgType, channel.Participants())
+}
+
+// SendEditedMessage fans out a message edited outside of a socket, such as
+// over REST, the same way as an edit frame.
+func SendEditedMessage(edited *db.MessageModel, mentioned []string) error {
+	channel, err := channelSrv.GetChannelById(edited.ChannelID)
+	if err != nil {
+		return err
+	}
+	return fanOutEdit(edited, mentioned, channel.Participants())
+}
@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/smtp"
 	"regexp"
 	"time"
 
@@ -41,19 +40,11 @@ func (v *EmailVerifier) GenerateOTP() string {
 }
 
 func (v *EmailVerifier) SendVerfication(userID string, to []string) error {
-	smtpHost := config.EMAIL_HOST
-	smtpPort := config.EMAIL_PORT
 	otp := v.GenerateOTP()
-	message := []byte(fmt.Sprintf("Verification code is %s", otp))
 
 	v.client.Set(context.Background(), userID, otp, time.Hour*1)
 
-	auth := smtp.PlainAuth("", config.EMAIL, config.EMAIL_PASSWORD, smtpHost)
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, config.EMAIL, to, message)
-	if err != nil {
-		return err
-	}
-	return nil
+	return Send(TemplateVerification, to, CodeData{Code: otp})
 }
 
 func (v *EmailVerifier) Verify(userID string, otp string) error {
@@ -80,20 +71,33 @@ func IsValidEmail(email string) bool {
 }
 
 func (v *EmailVerifier) SendInvitationMail(to []string, link string) error {
-	smtpHost := config.EMAIL_HOST
-	smtpPort := config.EMAIL_PORT
+	return Send(TemplateInvitation, to, LinkData{Link: link})
+}
 
-	subject := "You're Invited!"
-	body := fmt.Sprintf("You've been invited to join a workspace.\nClick the link to accept: %s", link)
+var outbox *Queue
 
-	message := []byte(fmt.Sprintf("Subject: %s\r\n\r\n%s", subject, body))
+// Connect starts the outbound queue on the transport named by MAIL_TRANSPORT,
+// "smtp" by default, "file" to write the mails to MAIL_OUTBOX_PATH or
+// "memory" to keep them in memory.
+func Connect() {
+	Use(newTransport())
+	logger.Logger.Info().Msgf("Sending mail with the %s transport", config.MAIL_TRANSPORT)
+}
 
-	auth := smtp.PlainAuth("", config.EMAIL, config.EMAIL_PASSWORD, smtpHost)
+// Use sends the mails through transport from now on.
+func Use(transport Transport) {
+	outbox = NewQueue(transport)
+}
 
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, config.EMAIL, to, message)
+// Send renders a template for data and queues it for the recipients.
+func Send(name Template, to []string, data any) error {
+	if outbox == nil {
+		return errors.New("mail is not connected")
+	}
+	msg, err := Render(name, data)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	msg.To = to
+	return outbox.Enqueue(msg)
 }
@@ -0,0 +1,124 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     Template
+		data     any
+		subject  string
+		contains string
+	}{
+		{TemplateVerification, CodeData{Code: "123456"}, "", "123456"},
+		{TemplateInvitation, LinkData{Link: "https://example.com/invite"}, "", "https://example.com/invite"},
+		{TemplateReset, LinkData{Link: "https://example.com/reset"}, "Reset your CollabTED password", "https://example.com/reset"},
+		{TemplateTaskAssignment, TaskAssignmentData{
+			UserName: "Jane", Title: "Ship it", Project: "Launch", Workspace: "Acme",
+			DueDate: time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC), Link: "https://example.com",
+		}, "", "Ship it"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.name), func(t *testing.T) {
+			msg, err := Render(tt.name, tt.data)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if msg.Subject == "" || strings.Contains(msg.Subject, "\n") {
+				t.Errorf("Subject = %q, want a single line", msg.Subject)
+			}
+			if tt.subject != "" && msg.Subject != tt.subject {
+				t.Errorf("Subject = %q, want %q", msg.Subject, tt.subject)
+			}
+			if !strings.Contains(msg.Text, tt.contains) {
+				t.Errorf("Text doesn't contain %q:\n%s", tt.contains, msg.Text)
+			}
+			if !strings.Contains(msg.HTML, tt.contains) {
+				t.Errorf("HTML doesn't contain %q:\n%s", tt.contains, msg.HTML)
+			}
+		})
+	}
+
+	if _, err := Render("unknown", nil); err == nil {
+		t.Error("Render() of an unknown template succeeded")
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	msg, err := Render(TemplateVerification, CodeData{Code: "<script>"})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if strings.Contains(msg.HTML, "<script>") {
+		t.Errorf("HTML isn't escaped:\n%s", msg.HTML)
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := &Message{
+		From:    "CollabTED <noreply@example.com>",
+		To:      []string{"jane@example.com", "joe@example.com"},
+		Subject: "Réunion à 10h",
+		Text:    "plain body",
+		HTML:    "<p>html body</p>",
+	}
+	b, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+
+	parsed, err := netmail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("the message can't be parsed: %v", err)
+	}
+	if got := parsed.Header.Get("To"); got != "jane@example.com, joe@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, msg.Subject)
+	}
+	for _, header := range []string{"Date", "Message-ID", "MIME-Version"} {
+		if parsed.Header.Get(header) == "" {
+			t.Errorf("%s header is missing", header)
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v), want multipart/alternative", mediaType, err)
+	}
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	want := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	}
+	for _, w := range want {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatalf("missing %s part: %v", w.contentType, err)
+		}
+		if got := part.Header.Get("Content-Type"); got != w.contentType {
+			t.Errorf("part Content-Type = %q, want %q", got, w.contentType)
+		}
+		// the multipart reader decodes quoted-printable parts itself
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != w.body {
+			t.Errorf("%s part = %q, want %q", w.contentType, body, w.body)
+		}
+	}
+	if _, err := parts.NextPart(); err != io.EOF {
+		t.Errorf("expected exactly two parts, got error %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package mail
+
+import (
+	"errors"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
+)
+
+const (
+	queueSize    = 256
+	queueWorkers = 4
+	maxAttempts  = 5
+	retryBackoff = 2 * time.Second
+)
+
+var ErrQueueFull = errors.New("mail queue is full")
+
+// Queue sends messages in the background, retrying failed deliveries with
+// an exponential backoff. Messages still queued when the process exits are
+// lost.
+type Queue struct {
+	transport Transport
+	jobs      chan job
+}
+
+type job struct {
+	msg     *Message
+	attempt int
+}
+
+func NewQueue(transport Transport) *Queue {
+	q := &Queue{
+		transport: transport,
+		jobs:      make(chan job, queueSize),
+	}
+	for i := 0; i < queueWorkers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *Queue) Enqueue(msg *Message) error {
+	return q.push(job{msg: msg, attempt: 1})
+}
+
+func (q *Queue) push(j job) error {
+	select {
+	case q.jobs <- j:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (q *Queue) work() {
+	for j := range q.jobs {
+		err := q.transport.Send(j.msg)
+		if err == nil {
+			continue
+		}
+
+		to := strings.Join(j.msg.To, ", ")
+		if permanent(err) || j.attempt >= maxAttempts {
+			logger.LogError().Msgf("Giving up on mail %q to %s after %d attempts: %v", j.msg.Subject, to, j.attempt, err)
+			continue
+		}
+
+		delay := retryBackoff << (j.attempt - 1)
+		logger.LogError().Msgf("Failed to send mail %q to %s, retrying in %s: %v", j.msg.Subject, to, delay, err)
+		retry := job{msg: j.msg, attempt: j.attempt + 1}
+		time.AfterFunc(delay, func() {
+			if err := q.push(retry); err != nil {
+				logger.LogError().Msgf("Dropping mail %q to %s: %v", retry.msg.Subject, to, err)
+			}
+		})
+	}
+}
+
+// permanent reports whether the SMTP server rejected the message for good,
+// such as for an unknown recipient, in which case it isn't retried.
+func permanent(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 500
+}
@@ -0,0 +1,65 @@
+package mail
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitSent waits for the queue workers to hand n messages to the transport.
+func waitSent(t *testing.T, transport *MemoryTransport, n int) []Message {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if sent := transport.Sent(); len(sent) >= n {
+			return sent
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d sent messages, got %d", n, len(transport.Sent()))
+	return nil
+}
+
+func TestSendQueuesRenderedMessage(t *testing.T) {
+	transport := &MemoryTransport{}
+	Use(transport)
+	t.Cleanup(func() { outbox = nil })
+
+	err := Send(TemplateReset, []string{"jane@example.com"}, LinkData{Link: "https://example.com/reset?token=abc"})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	sent := waitSent(t, transport, 1)
+	msg := sent[0]
+	if len(msg.To) != 1 || msg.To[0] != "jane@example.com" {
+		t.Errorf("To = %v, want [jane@example.com]", msg.To)
+	}
+	if msg.Subject == "" {
+		t.Error("Subject is empty")
+	}
+	for name, body := range map[string]string{"text": msg.Text, "html": msg.HTML} {
+		if !strings.Contains(body, "https://example.com/reset?token=abc") {
+			t.Errorf("%s part doesn't contain the link:\n%s", name, body)
+		}
+	}
+}
+
+func TestSendWithoutConnect(t *testing.T) {
+	outbox = nil
+	if err := Send(TemplateReset, []string{"jane@example.com"}, LinkData{}); err == nil {
+		t.Fatal("Send succeeded without a transport")
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	// a queue without workers is never drained
+	q := &Queue{transport: &MemoryTransport{}, jobs: make(chan job, 1)}
+	if err := q.Enqueue(&Message{}); err != nil {
+		t.Fatalf("first Enqueue: %v", err)
+	}
+	if err := q.Enqueue(&Message{}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("second Enqueue = %v, want ErrQueueFull", err)
+	}
+}
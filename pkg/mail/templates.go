@@ -0,0 +1,103 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Template names an email, made of templates/<name>.txt, which also defines
+// its "subject", and templates/<name>.html, which defines the "content" of
+// the shared layout.
+type Template string
+
+const (
+	TemplateVerification   Template = "verification"
+	TemplateInvitation     Template = "invitation"
+	TemplateReset          Template = "reset"
+	TemplateDigest         Template = "digest"
+	TemplateTaskAssignment Template = "task_assignment"
+)
+
+type CodeData struct {
+	Code string
+}
+
+type LinkData struct {
+	Link string
+}
+
+type TaskAssignmentData struct {
+	UserName  string
+	Title     string
+	Project   string
+	Workspace string
+	DueDate   time.Time
+	Link      string
+}
+
+//go:embed templates
+var templateFS embed.FS
+
+var funcs = map[string]any{
+	"when": func(t time.Time) string {
+		return t.UTC().Format("Mon Jan 2, 15:04 UTC")
+	},
+}
+
+type parsedTemplate struct {
+	text *template.Template
+	html *htmltemplate.Template
+}
+
+var templates = mustParseTemplates(
+	TemplateVerification,
+	TemplateInvitation,
+	TemplateReset,
+	TemplateDigest,
+	TemplateTaskAssignment,
+)
+
+func mustParseTemplates(names ...Template) map[Template]parsedTemplate {
+	parsed := make(map[Template]parsedTemplate, len(names))
+	for _, name := range names {
+		text := template.Must(template.New(string(name)+".txt").Funcs(funcs).ParseFS(
+			templateFS, "templates/"+string(name)+".txt",
+		))
+		html := htmltemplate.Must(htmltemplate.New("layout.html").Funcs(funcs).ParseFS(
+			templateFS, "templates/layout.html", "templates/"+string(name)+".html",
+		))
+		parsed[name] = parsedTemplate{text: text, html: html}
+	}
+	return parsed
+}
+
+// Render builds the message of a template for data, without recipients.
+func Render(name Template, data any) (*Message, error) {
+	t, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown mail template: %s", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		From:    sender(),
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,93 @@
+package mail
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/config"
+)
+
+// Transport hands messages over for delivery.
+type Transport interface {
+	Send(msg *Message) error
+}
+
+// SMTPTransport sends messages through an SMTP server. Authentication is
+// skipped without a password, for local servers such as MailHog.
+type SMTPTransport struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+func (t *SMTPTransport) Send(msg *Message) error {
+	b, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if t.Password != "" {
+		auth = smtp.PlainAuth("", t.Username, t.Password, t.Host)
+	}
+	return smtp.SendMail(t.Host+":"+t.Port, auth, t.Username, msg.To, b)
+}
+
+// FileTransport writes every message as an .eml file to Dir, to read the
+// mails sent in development.
+type FileTransport struct {
+	Dir string
+}
+
+func (t *FileTransport) Send(msg *Message) error {
+	b, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(t.Dir, name), b, 0o644)
+}
+
+// MemoryTransport keeps the messages it is given, for tests.
+type MemoryTransport struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (t *MemoryTransport) Send(msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, *msg)
+	return nil
+}
+
+func (t *MemoryTransport) Sent() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Message(nil), t.sent...)
+}
+
+// newTransport returns the transport named by MAIL_TRANSPORT.
+func newTransport() Transport {
+	switch config.MAIL_TRANSPORT {
+	case "file":
+		return &FileTransport{Dir: config.MAIL_OUTBOX_PATH}
+	case "memory":
+		return &MemoryTransport{}
+	default:
+		return &SMTPTransport{
+			Host:     config.EMAIL_HOST,
+			Port:     config.EMAIL_PORT,
+			Username: config.EMAIL,
+			Password: config.EMAIL_PASSWORD,
+		}
+	}
+}
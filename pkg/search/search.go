@@ -0,0 +1,183 @@
+package search
+
+import (
+	"errors"
+	"time"
+
+	"github.com/CollabTED/CollabTed-Backend/config"
+	"github.com/CollabTED/CollabTed-Backend/pkg/logger"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+var index bleve.Index
+
+// MessageDoc is the indexed form of a channel message.
+type MessageDoc struct {
+	ID              string    `json:"id"`
+	WorkspaceID     string    `json:"workspaceId"`
+	ChannelID       string    `json:"channelId"`
+	SenderID        string    `json:"senderId"`
+	Content         string    `json:"content"`
+	AttachmentTitle string    `json:"attachmentTitle"`
+	HasAttachment   bool      `json:"hasAttachment"`
+	IsPinned        bool      `json:"isPinned"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// Query describes a message search. ChannelIDs restricts the hits to the
+// channels the searching user can see and must not be empty.
+type Query struct {
+	Text          string
+	WorkspaceID   string
+	ChannelIDs    []string
+	SenderID      string
+	After         time.Time
+	Before        time.Time
+	HasAttachment *bool
+	IsPinned      *bool
+	Size          int
+	Offset        int
+}
+
+// Connect opens the message index, creating it on first run. It reports
+// whether a new, empty index was created so callers can backfill it, and
+// whether the index is usable at all.
+func Connect() (created bool, ok bool) {
+	var err error
+	index, err = bleve.Open(config.SEARCH_INDEX_PATH)
+	if err == nil {
+		logger.Logger.Info().Msg("Opened search index")
+		return false, true
+	}
+	if !errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		logger.Logger.Err(err).Msg("Failed to open search index")
+		return false, false
+	}
+
+	index, err = bleve.New(config.SEARCH_INDEX_PATH, newMapping())
+	if err != nil {
+		logger.Logger.Err(err).Msg("Failed to create search index")
+		return false, false
+	}
+	logger.Logger.Info().Msg("Created search index")
+	return true, true
+}
+
+func newMapping() mapping.IndexMapping {
+	keywordField := bleve.NewTextFieldMapping()
+	keywordField.Analyzer = keyword.Name
+
+	textField := bleve.NewTextFieldMapping()
+	boolField := bleve.NewBooleanFieldMapping()
+	dateField := bleve.NewDateTimeFieldMapping()
+
+	doc := bleve.NewDocumentMapping()
+	doc.AddFieldMappingsAt("workspaceId", keywordField)
+	doc.AddFieldMappingsAt("channelId", keywordField)
+	doc.AddFieldMappingsAt("senderId", keywordField)
+	doc.AddFieldMappingsAt("content", textField)
+	doc.AddFieldMappingsAt("attachmentTitle", textField)
+	doc.AddFieldMappingsAt("hasAttachment", boolField)
+	doc.AddFieldMappingsAt("isPinned", boolField)
+	doc.AddFieldMappingsAt("createdAt", dateField)
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	return m
+}
+
+func IndexMessage(doc MessageDoc) error {
+	if index == nil {
+		return errors.New("search index not initialized")
+	}
+	return index.Index(doc.ID, doc)
+}
+
+func DeleteMessages(ids ...string) error {
+	if index == nil {
+		return errors.New("search index not initialized")
+	}
+	batch := index.NewBatch()
+	for _, id := range ids {
+		batch.Delete(id)
+	}
+	return index.Batch(batch)
+}
+
+// Search returns the IDs of the matching messages, best match first, and the
+// total number of hits.
+func Search(q Query) ([]string, uint64, error) {
+	if index == nil {
+		return nil, 0, errors.New("search index not initialized")
+	}
+	if len(q.ChannelIDs) == 0 {
+		return []string{}, 0, nil
+	}
+
+	text := bleve.NewDisjunctionQuery(
+		fieldMatch(q.Text, "content"),
+		fieldMatch(q.Text, "attachmentTitle"),
+	)
+
+	channels := bleve.NewDisjunctionQuery()
+	for _, channelID := range q.ChannelIDs {
+		channels.AddQuery(term(channelID, "channelId"))
+	}
+
+	conjuncts := []query.Query{
+		text,
+		term(q.WorkspaceID, "workspaceId"),
+		channels,
+	}
+	if q.SenderID != "" {
+		conjuncts = append(conjuncts, term(q.SenderID, "senderId"))
+	}
+	if !q.After.IsZero() || !q.Before.IsZero() {
+		dates := bleve.NewDateRangeQuery(q.After, q.Before)
+		dates.SetField("createdAt")
+		conjuncts = append(conjuncts, dates)
+	}
+	if q.HasAttachment != nil {
+		conjuncts = append(conjuncts, boolean(*q.HasAttachment, "hasAttachment"))
+	}
+	if q.IsPinned != nil {
+		conjuncts = append(conjuncts, boolean(*q.IsPinned, "isPinned"))
+	}
+
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(conjuncts...), q.Size, q.Offset, false)
+	res, err := index.Search(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids := make([]string, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids, res.Total, nil
+}
+
+func fieldMatch(text, field string) query.Query {
+	q := bleve.NewMatchQuery(text)
+	q.SetField(field)
+	q.SetOperator(query.MatchQueryOperatorAnd)
+	return q
+}
+
+func term(value, field string) query.Query {
+	q := bleve.NewTermQuery(value)
+	q.SetField(field)
+	return q
+}
+
+func boolean(value bool, field string) query.Query {
+	q := bleve.NewBoolFieldQuery(value)
+	q.SetField(field)
+	return q
+}
@@ -1,12 +1,23 @@
 package types
 
+import "time"
+
 type AppState struct {
-	UserWorkspaceId string   `json:"userWorkspaceId"`
-	MissedCalls     []string `json:"missedCalls"`
-	UnreadChannels  []string `json:"unreadChannels"`
+	UserWorkspaceId string          `json:"userWorkspaceId"`
+	MissedCalls     []string        `json:"missedCalls"`
+	UnreadChannels  []ChannelUnread `json:"unreadChannels"`
+}
+
+type ChannelUnread struct {
+	ChannelID         string    `json:"channelId"`
+	LastReadMessageID string    `json:"lastReadMessageId"`
+	LastReadAt        time.Time `json:"lastReadAt"`
+	UnreadCount       int       `json:"unreadCount"`
+	MentionCount      int       `json:"mentionCount"`
 }
 
 type AppStateUpdateRequest struct {
-	Action string `json:"action"`
-	Value  string `json:"value"`
+	Action    string `json:"action"`
+	Value     string `json:"value"`
+	MessageID string `json:"messageId"`
 }
@@ -0,0 +1,13 @@
+package types
+
+import "time"
+
+type BotD struct {
+	Name string `json:"name"`
+}
+
+type BotTokenD struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
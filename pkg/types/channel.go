@@ -2,11 +2,21 @@ package types
 
 type ChannelD struct {
 	Name            string   `json:"name"`
+	Kind            string   `json:"kind"`
 	WorkspaceID     string   `json:"workspaceID"`
 	CreatorID       string   `json:"creatorID"`
 	ParticipantsIDS []string `json:"participantsIds"`
 }
 
+type ChannelVisibilityD struct {
+	Kind string `json:"kind"`
+}
+
+type DirectChannelD struct {
+	WorkspaceID string   `json:"workspaceID"`
+	UsersID     []string `json:"usersID"`
+}
+
 type ParticipantD struct {
 	WorkspaceID string   `json:"workspaceID"`
 	UsersID     []string `json:"usersID"`
@@ -18,10 +28,35 @@ type MessageD struct {
 	ChannelID string `json:"channelID"`
 	Content   string `json:"content"`
 
-	IsReply         bool   `json:"isReply"`
-	ReplyToMessage  string `json:"replyToMessage"`
-	ReplyToUserName string `json:"replyToUserName"`
+	// ParentID makes the message a reply in the thread of that message
+	ParentID string `json:"parentID"`
+
+	// IsSystem marks membership announcements, never set by clients
+	IsSystem bool `json:"-"`
 
 	AttachmentLink  string `json:"attachmentLink"`
 	AttachmentTitle string `json:"attachmentTitle"`
 }
+
+type EditMessageD struct {
+	Content string `json:"content"`
+}
+
+type ReactionD struct {
+	Emoji string `json:"emoji"`
+}
+
+type ReactionCount struct {
+	Emoji            string   `json:"emoji"`
+	Count            int      `json:"count"`
+	UserWorkspaceIDs []string `json:"userWorkspaceIds"`
+}
+
+// MessageCursorD anchors a page of channel messages. At most one of Before,
+// After and Around is expected; none returns the latest messages.
+type MessageCursorD struct {
+	Before string
+	After  string
+	Around string
+	Limit  int
+}
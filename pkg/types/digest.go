@@ -0,0 +1,47 @@
+package types
+
+import "time"
+
+type DigestSettingsD struct {
+	Frequency string `json:"frequency"`
+	Hour      int    `json:"hour"`
+	Weekday   int    `json:"weekday"`
+	Timezone  string `json:"timezone"`
+}
+
+// Digest is the activity a user missed since their last digest email.
+type Digest struct {
+	UserName       string
+	Since          time.Time
+	AppURL         string
+	Mentions       []DigestMessage
+	DirectMessages []DigestMessage
+	Tasks          []DigestTask
+	Events         []DigestEvent
+}
+
+type DigestMessage struct {
+	Workspace string
+	Channel   string
+	Sender    string
+	Content   string
+	SentAt    time.Time
+}
+
+type DigestTask struct {
+	Workspace string
+	Project   string
+	Title     string
+	DueDate   time.Time
+}
+
+type DigestEvent struct {
+	Workspace string
+	Name      string
+	StartTime time.Time
+	MeetLink  string
+}
+
+func (d Digest) IsEmpty() bool {
+	return len(d.Mentions) == 0 && len(d.DirectMessages) == 0 && len(d.Tasks) == 0 && len(d.Events) == 0
+}
@@ -7,5 +7,9 @@ type Claims struct {
 	Name           string
 	Email          string
 	ProfilePicture string
+	// set when the request is made with a bot token instead of a session
+	TokenID     string   `json:",omitempty"`
+	WorkspaceID string   `json:",omitempty"`
+	Scopes      []string `json:",omitempty"`
 	jwt.RegisteredClaims
 }
@@ -3,10 +3,12 @@ package types
 type NotifType string
 
 const (
-	MESSAGE_NOTIFICATION NotifType = "message"
-	CALL_NOTIFICATION    NotifType = "call"
-	KICK_NOTIFICATION    NotifType = "kick"
-	JOIN_NOTIFICATION    NotifType = "join"
+	MESSAGE_NOTIFICATION  NotifType = "message"
+	CALL_NOTIFICATION     NotifType = "call"
+	KICK_NOTIFICATION     NotifType = "kick"
+	JOIN_NOTIFICATION     NotifType = "join"
+	MENTION_NOTIFICATION  NotifType = "mention"
+	REMINDER_NOTIFICATION NotifType = "reminder"
 )
 
 type PingNotification struct {
@@ -17,6 +19,22 @@ type PingNotification struct {
 	SenderID string    `json:"senderID"`
 }
 
+type MentionNotification struct {
+	Type      NotifType `json:"type"`
+	Content   string    `json:"content"`
+	Sender    string    `json:"senderName"`
+	Channel   string    `json:"channelID"`
+	SenderID  string    `json:"senderID"`
+	MessageID string    `json:"messageID"`
+}
+
+type ReminderNotification struct {
+	Type      NotifType `json:"type"`
+	Content   string    `json:"content"`
+	Channel   string    `json:"channelID"`
+	MessageID string    `json:"messageID"`
+}
+
 type CallNotification struct {
 	Type     NotifType `json:"type"`
 	RoomID   string    `json:"roomId"`
@@ -32,3 +50,16 @@ type JoinUser struct {
 	Type        NotifType `json:"type"`
 	WorkspaceID string    `json:"workspaceId"`
 }
+
+type NotificationPreferenceD struct {
+	WorkspaceID string `json:"workspaceId"`
+	ChannelID   string `json:"channelId"`
+	Level       string `json:"level"`
+}
+
+type QuietHoursD struct {
+	Enabled  bool   `json:"enabled"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
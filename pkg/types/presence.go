@@ -0,0 +1,6 @@
+package types
+
+type PresenceD struct {
+	Presence   string `json:"presence"`
+	StatusText string `json:"statusText"`
+}
@@ -0,0 +1,25 @@
+package types
+
+import "time"
+
+type ScheduledMessageD struct {
+	ChannelID       string    `json:"channelId"`
+	Content         string    `json:"content"`
+	ParentID        string    `json:"parentId"`
+	AttachmentLink  string    `json:"attachmentLink"`
+	AttachmentTitle string    `json:"attachmentTitle"`
+	SendAt          time.Time `json:"sendAt"`
+}
+
+type ReminderD struct {
+	MessageID string    `json:"messageId"`
+	Note      string    `json:"note"`
+	RemindAt  time.Time `json:"remindAt"`
+}
+
+// EditScheduledD updates a pending scheduled message or reminder, nil fields
+// are left untouched.
+type EditScheduledD struct {
+	Content *string    `json:"content"`
+	SendAt  *time.Time `json:"sendAt"`
+}
@@ -0,0 +1,15 @@
+package types
+
+import "time"
+
+type SearchD struct {
+	Query         string
+	ChannelID     string
+	SenderID      string
+	After         time.Time
+	Before        time.Time
+	HasAttachment *bool
+	IsPinned      *bool
+	Limit         int
+	Offset        int
+}
@@ -0,0 +1,18 @@
+package types
+
+type WebhookD struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+type IncomingWebhookD struct {
+	Name string `json:"name"`
+}
+
+// IncomingWebhookPayload is what integrations POST to an incoming webhook.
+type IncomingWebhookPayload struct {
+	Text           string `json:"text"`
+	Title          string `json:"title"`
+	AttachmentLink string `json:"attachmentLink"`
+}